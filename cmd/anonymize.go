@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/jerkeeler/restoration/parser"
+	"github.com/spf13/cobra"
+)
+
+var anonymizeOutputPath string
+var anonymizeAliases []string
+var anonymizeAllowResidual bool
+
+var anonymizeCmd = &cobra.Command{
+	Use:   "anonymize [replay]",
+	Short: "Writes a copy of a .mythrec file with the player names and profile ids replaced",
+	Long: `Writes a copy of a .mythrec file with the player names and rlinkid profile ids replaced, so that replays can
+be shared publicly without exposing accounts.
+
+Players are renamed to "Player<num>" unless an alias is given with --alias "name=alias", and profile ids are replaced
+by the negated player number (-1, -2, ...), which no real account has. The mapping is printed once the anonymized
+replay is written. The rest of the file is copied as is, the header is only recompressed.
+
+If a player name or profile id is still found anywhere else in the replay, nothing is written and the command fails.
+Names and profile ids shorter than 3 characters are not checked, a warning is logged instead. Pass --allow-residual to
+write the replay anyway.
+	`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Run: func(cmd *cobra.Command, args []string) {
+		absPath, err := validateAndExpandPath(args[0])
+		if err != nil {
+			fmt.Printf("Error with filepath: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		aliases := make(map[string]string)
+		for _, alias := range anonymizeAliases {
+			name, value, found := strings.Cut(alias, "=")
+			if !found || name == "" || value == "" {
+				fmt.Fprintf(os.Stderr, "error: alias must look like name=alias, got %q\n", alias)
+				os.Exit(1)
+				return
+			}
+			aliases[name] = value
+		}
+
+		outputPath, err := filepath.Abs(filepath.Clean(anonymizeOutputPath))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		if outputPath == absPath {
+			fmt.Fprintf(os.Stderr, "error: output path must differ from the replay\n")
+			os.Exit(1)
+			return
+		}
+
+		playerAliases, err := parser.AnonymizeReplay(absPath, outputPath, aliases, anonymizeAllowResidual, isGzip)
+		if errors.Is(err, parser.ErrResidualNames) {
+			fmt.Fprintf(os.Stderr, "error: %v, pass --allow-residual to write it anyway\n", err)
+			os.Exit(1)
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		fmt.Print(parser.PlayerAliasesToText(playerAliases))
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(anonymizeCmd)
+	anonymizeCmd.Flags().StringVarP(&anonymizeOutputPath, "output", "o", "", "Path to write the anonymized replay to")
+	anonymizeCmd.MarkFlagRequired("output")
+	anonymizeCmd.Flags().StringArrayVar(
+		&anonymizeAliases,
+		"alias",
+		nil,
+		"Rename a player, given as name=alias, can be repeated",
+	)
+	anonymizeCmd.Flags().BoolVar(
+		&anonymizeAllowResidual,
+		"allow-residual",
+		false,
+		"Write the replay even if player names or profile ids are still found outside of the profile keys",
+	)
+}
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/jerkeeler/restoration/parser"
+	"github.com/spf13/cobra"
+)
+
+var buildOrderUntil time.Duration
+var buildOrderJson bool = false
+var buildOrderPrettyPrint bool = false
+
+var buildOrderCmd = &cobra.Command{
+	Use:   "buildorder [replay]",
+	Short: "Prints a condensed build order for each player in a .mythrec file",
+	Long: `Prints a condensed, human-readable build order for each player in a .mythrec file.
+
+The build order contains trains (with batch sizes), buildings, research, prequeued techs, age ups and god powers
+with the game time they were clicked. Consecutive trains or buildings of the same type are folded into one step.
+Use --until to only include the opening, e.g. --until 10m for the first 10 minutes of game time.
+	`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Run: func(cmd *cobra.Command, args []string) {
+		absPath, err := validateAndExpandPath(args[0])
+		if err != nil {
+			fmt.Printf("Error with filepath: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		var output string
+		if buildOrderJson {
+			output, err = parser.BuildOrderToJson(absPath, buildOrderUntil.Seconds(), buildOrderPrettyPrint, isGzip)
+		} else {
+			output, err = parser.BuildOrderToText(absPath, buildOrderUntil.Seconds(), isGzip)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		fmt.Println(output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(buildOrderCmd)
+	buildOrderCmd.Flags().DurationVar(
+		&buildOrderUntil,
+		"until",
+		10*time.Minute,
+		"Only include commands up to this game time, e.g. 10m or 7m30s. Use 0 for the whole game",
+	)
+	buildOrderCmd.Flags().BoolVar(&buildOrderJson, "json", false, "Output the build order as JSON instead of text")
+	buildOrderCmd.Flags().BoolVar(&buildOrderPrettyPrint, "pretty-print", false, "Pretty print the output JSON")
+}
@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jerkeeler/restoration/parser"
+	"github.com/spf13/cobra"
+)
+
+var rulesPath string
+var checkJson bool = false
+var checkPrettyPrint bool = false
+
+var checkCmd = &cobra.Command{
+	Use:   "check [replays...]",
+	Short: "Reports which rules from a rules file fired in one or more .mythrec files",
+	Long: `Evaluates a declarative rules file against the game commands and player metadata of one or more .mythrec
+files and reports which rules fired for which players.
+
+Rules can match on command type, payload fields, time windows, counts and commands that happened shortly before
+another command. Rules are written in YAML, see rules/checks.yaml for the format and some examples.
+	`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		ruleSet, err := parser.LoadRules(rulesPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		replayPaths := make([]string, 0)
+		for _, arg := range args {
+			absPath, err := validateAndExpandPath(arg)
+			if err != nil {
+				fmt.Printf("Error with filepath %s: %v\n", arg, err)
+				os.Exit(1)
+				return
+			}
+			replayPaths = append(replayPaths, absPath)
+		}
+
+		var output string
+		if checkJson {
+			output, err = parser.CheckRulesToJson(replayPaths, ruleSet, checkPrettyPrint, isGzip)
+		} else {
+			output, err = parser.CheckRulesToText(replayPaths, ruleSet, isGzip)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		fmt.Println(output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(checkCmd)
+	checkCmd.Flags().StringVar(&rulesPath, "rules", "", "Path to the YAML rules file to evaluate")
+	checkCmd.MarkFlagRequired("rules")
+	checkCmd.Flags().BoolVar(&checkJson, "json", false, "Output the fired rules as JSON instead of text")
+	checkCmd.Flags().BoolVar(&checkPrettyPrint, "pretty-print", false, "Pretty print the output JSON")
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jerkeeler/restoration/parser"
+	"github.com/spf13/cobra"
+)
+
+var diagnoseJson bool = false
+var diagnosePrettyPrint bool = false
+var diagnoseWindow int
+var diagnoseRadius int
+
+var diagnoseCmd = &cobra.Command{
+	Use:   "diagnose [replay]",
+	Short: "Searches for the command body length a patch changed when a replay fails to parse",
+	Long: `When a patch changes the body length of a command type, parsing fails a little after the first command of
+that type. The diagnose command collects the command types parsed right before the failure and reparses the command
+stream with every body length within --radius bytes of the current one, one type at a time. It reports the lengths
+that get further than the current layouts, the ones under which the whole stream parses (sequential entry indices,
+valid footers) first.
+
+A clean candidate is a strong hint, not a proof: confirm it with hexdump --annotate and update parser/layouts.go.
+	`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Run: func(cmd *cobra.Command, args []string) {
+		absPath, err := validateAndExpandPath(args[0])
+		if err != nil {
+			fmt.Printf("Error with filepath: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		diagnosis, err := parser.DiagnoseLayouts(absPath, diagnoseWindow, diagnoseRadius, isGzip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		if !diagnoseJson {
+			fmt.Print(parser.LayoutDiagnosisToText(diagnosis))
+			return
+		}
+		output, err := parser.LayoutDiagnosisToJson(diagnosis, diagnosePrettyPrint)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		fmt.Println(output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diagnoseCmd)
+	diagnoseCmd.Flags().BoolVar(&diagnoseJson, "json", false, "Output the diagnosis as JSON instead of text")
+	diagnoseCmd.Flags().BoolVar(&diagnosePrettyPrint, "pretty-print", false, "Pretty print the output JSON")
+	diagnoseCmd.Flags().IntVar(
+		&diagnoseWindow,
+		"window",
+		3,
+		"Number of command lists before the failure whose command types are suspects",
+	)
+	diagnoseCmd.Flags().IntVar(&diagnoseRadius, "radius", 8, "Search lengths up to this many bytes from the current one")
+}
@@ -0,0 +1,54 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jerkeeler/restoration/parser"
+	"github.com/spf13/cobra"
+)
+
+var hexdumpAnnotate bool = false
+var hexdumpInner bool = false
+var hexdumpMaxTicks int
+
+var hexdumpCmd = &cobra.Command{
+	Use:   "hexdump [replay]",
+	Short: "Prints a hexdump of the command section of a .mythrec file",
+	Long: `Prints a hexdump of the command section of a .mythrec file, or of the decompressed inner buffer with --inner.
+
+With --annotate every byte range of the command section is labelled with what the parser thinks it is: command list
+headers, command preambles, source units and vectors, preargument bytes, refiner fields, footers and entry indices.
+Ranges the parser reads but never interprets are marked with "!". If the command stream fails to parse, the dump
+stops at the failure and prints the error followed by the raw bytes after it.
+	`,
+	Args: cobra.MatchAll(cobra.ExactArgs(1), cobra.OnlyValidArgs),
+	Run: func(cmd *cobra.Command, args []string) {
+		absPath, err := validateAndExpandPath(args[0])
+		if err != nil {
+			fmt.Printf("Error with filepath: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		output, err := parser.HexdumpToText(absPath, hexdumpInner, hexdumpAnnotate, hexdumpMaxTicks, isGzip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		fmt.Print(output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(hexdumpCmd)
+	hexdumpCmd.Flags().BoolVar(&hexdumpAnnotate, "annotate", false, "Label the byte ranges of the command section")
+	hexdumpCmd.Flags().BoolVar(&hexdumpInner, "inner", false, "Dump the decompressed inner buffer instead")
+	hexdumpCmd.Flags().IntVar(
+		&hexdumpMaxTicks,
+		"max-ticks",
+		0,
+		"Only annotate the first N command lists, 0 annotates all of them",
+	)
+}
@@ -0,0 +1,43 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jerkeeler/restoration/parser"
+	"github.com/spf13/cobra"
+)
+
+var layoutsJson bool = false
+var layoutsPrettyPrint bool = false
+
+var layoutsCmd = &cobra.Command{
+	Use:   "layouts",
+	Short: "Prints the byte layout of every command type the parser knows",
+	Long: `Prints the command layout table the parser reads command bodies with: for every command type the field
+names, types and offsets, and the range of builds the layout applies to. Current layouts start at the build they were
+checked against, they may apply to earlier builds too.
+
+Use --json to export the table for external tools, so they don't need to keep their own copy of the layouts.
+	`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if !layoutsJson {
+			fmt.Print(parser.LayoutsToText())
+			return
+		}
+		output, err := parser.LayoutsToJson(layoutsPrettyPrint)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+		fmt.Println(output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(layoutsCmd)
+	layoutsCmd.Flags().BoolVar(&layoutsJson, "json", false, "Output the layouts as JSON instead of text")
+	layoutsCmd.Flags().BoolVar(&layoutsPrettyPrint, "pretty-print", false, "Pretty print the output JSON")
+}
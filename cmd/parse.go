@@ -15,6 +15,9 @@ var quiet bool = false
 var prettyPrint bool = false
 var slim bool = false
 var stats bool = false
+var openingRulesPath string
+var sourceUnits bool = false
+var rawCommands bool = false
 
 // parseCmd represents the parse command
 var parseCmd = &cobra.Command{
@@ -30,7 +33,24 @@ var parseCmd = &cobra.Command{
 			return
 		}
 
-		json, err := parser.ParseToJson(absPath, prettyPrint, slim, stats, isGzip)
+		var openingRules *parser.OpeningRules
+		if openingRulesPath != "" {
+			openingRules, err = parser.LoadOpeningRules(openingRulesPath)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+				return
+			}
+		}
+
+		json, err := parser.ParseToJson(absPath, prettyPrint, parser.ParseOptions{
+			Slim:         slim,
+			Stats:        stats,
+			IsGzip:       isGzip,
+			OpeningRules: openingRules,
+			SourceUnits:  sourceUnits,
+			RawCommands:  rawCommands,
+		})
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "error: %v\n", err)
 			os.Exit(1)
@@ -66,6 +86,24 @@ func init() {
 		false,
 		"Stats mode, add stats to the output, you cannot use this with slim mode",
 	)
+	parseCmd.Flags().StringVar(
+		&openingRulesPath,
+		"opening-rules",
+		"",
+		"Label each player's opening using the rules in the provided YAML file, see rules/openings.yaml",
+	)
+	parseCmd.Flags().BoolVar(
+		&sourceUnits,
+		"source-units",
+		false,
+		"Add the ids of the units acting on each game command and the per tick unit selections to the output",
+	)
+	parseCmd.Flags().BoolVar(
+		&rawCommands,
+		"raw-commands",
+		false,
+		"Add every command as read from the command stream, including the ones without a formatter, with its hex body",
+	)
 
 	parseCmd.PreRun = func(cmd *cobra.Command, args []string) {
 		if outputPath == "" {
@@ -0,0 +1,59 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/jerkeeler/restoration/parser"
+	"github.com/spf13/cobra"
+)
+
+var researchCommandType int
+var researchCsv bool = false
+
+var researchCommandsCmd = &cobra.Command{
+	Use:   "research-commands [directory]",
+	Short: "Summarizes every byte of a command type across a directory of .mythrec files",
+	Long: `Collects every instance of a command type from the replays in a directory and reports, for each byte
+position of the command body, the distribution of its values, how often it is constant, how it correlates with game
+time and how strongly it is associated with the player and the neighbouring commands. The bytes following the body,
+up to the next command, are summarized too, in case the layout stops short.
+
+This is meant for decoding commands whose layout is still unknown, e.g.:
+
+    restoration research-commands ./replays --type 55 --csv > command55.csv
+	`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		inputDir := args[0]
+		if fileInfo, err := os.Stat(inputDir); err != nil || !fileInfo.IsDir() {
+			fmt.Fprintf(os.Stderr, "error: '%s' is not a valid directory\n", inputDir)
+			os.Exit(1)
+		}
+
+		research, err := parser.ResearchCommandsInDir(inputDir, researchCommandType, isGzip)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+			os.Exit(1)
+			return
+		}
+
+		output := parser.CommandResearchToText(research)
+		if researchCsv {
+			output, err = parser.CommandResearchToCsv(research)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "error: %v\n", err)
+				os.Exit(1)
+				return
+			}
+		}
+		fmt.Print(output)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(researchCommandsCmd)
+	researchCommandsCmd.Flags().IntVar(&researchCommandType, "type", -1, "The command type id to research")
+	researchCommandsCmd.MarkFlagRequired("type")
+	researchCommandsCmd.Flags().BoolVar(&researchCsv, "csv", false, "Output the per byte summary as CSV")
+}
@@ -0,0 +1,496 @@
+package encoder
+
+import (
+	"fmt"
+
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// Commands are encoded from the parser's command layout table (parser.CurrentLayouts), the same table the refiners
+// read them with. A typed command names the values of the layout fields it sets, every other byte of the body is zero.
+// Command types without an interpreted field can be written with RawCommand.
+
+// Modifier flags of the first preargument byte, see parser.CommandModifiers
+const (
+	repeatModifierFlag uint8 = 1
+	queuedModifierFlag uint8 = 2
+)
+
+// CommandHeader holds what every command carries besides its body. Tick is the index of the command list the command
+// is issued in, the game runs 20 ticks a second.
+type CommandHeader struct {
+	Player        int
+	Tick          int
+	SourceUnits   []uint32
+	SourceVectors []parser.Vector3
+	Queued        bool
+	Repeat        bool
+}
+
+func (header CommandHeader) commandHeader() CommandHeader {
+	return header
+}
+
+type Command interface {
+	commandHeader() CommandHeader
+	commandType() int
+	// fieldValues maps layout field names to their values, an int8, int32, float32 or parser.Vector3 depending on
+	// the field type
+	fieldValues() map[string]interface{}
+}
+
+// RawCommand is a command with a literal body, its length must match one of the layouts of the command type
+type RawCommand struct {
+	CommandHeader
+	Type int
+	Body []byte
+}
+
+func (cmd RawCommand) commandType() int                    { return cmd.Type }
+func (cmd RawCommand) fieldValues() map[string]interface{} { return nil }
+
+type Task struct {
+	CommandHeader
+	TargetUnitId   int32
+	TargetLocation parser.Vector3
+	TargetRange    float32
+}
+
+func (cmd Task) commandType() int { return 0 }
+func (cmd Task) fieldValues() map[string]interface{} {
+	return map[string]interface{}{
+		"targetUnitId":   cmd.TargetUnitId,
+		"targetLocation": cmd.TargetLocation,
+		"targetRange":    cmd.TargetRange,
+	}
+}
+
+type Research struct {
+	CommandHeader
+	TechId int32
+}
+
+func (cmd Research) commandType() int { return 1 }
+func (cmd Research) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"techId": cmd.TechId}
+}
+
+// Train queues NumUnits units, 5 for a shift-click. The parser reads a NumUnits of 0 as 1.
+type Train struct {
+	CommandHeader
+	ProtoUnitId int32
+	NumUnits    int8
+}
+
+func (cmd Train) commandType() int { return 2 }
+func (cmd Train) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"protoUnitId": cmd.ProtoUnitId, "numUnits": cmd.NumUnits}
+}
+
+type Build struct {
+	CommandHeader
+	ProtoBuildingId int32
+	Location        parser.Vector3
+}
+
+func (cmd Build) commandType() int { return 3 }
+func (cmd Build) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"protoBuildingId": cmd.ProtoBuildingId, "location": cmd.Location}
+}
+
+type SetGatherPoint struct {
+	CommandHeader
+	TargetUnitId int32
+	Location     parser.Vector3
+	TargetRange  float32
+}
+
+func (cmd SetGatherPoint) commandType() int { return 4 }
+func (cmd SetGatherPoint) fieldValues() map[string]interface{} {
+	return map[string]interface{}{
+		"targetUnitId": cmd.TargetUnitId,
+		"location":     cmd.Location,
+		"targetRange":  cmd.TargetRange,
+	}
+}
+
+type Delete struct {
+	CommandHeader
+	UnitId int32
+}
+
+func (cmd Delete) commandType() int { return 7 }
+func (cmd Delete) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"unitId": cmd.UnitId}
+}
+
+type Stop struct {
+	CommandHeader
+}
+
+func (cmd Stop) commandType() int                    { return 9 }
+func (cmd Stop) fieldValues() map[string]interface{} { return nil }
+
+type UseProtoPower struct {
+	CommandHeader
+	ProtoPowerId int32
+	Location1    parser.Vector3
+	Location2    parser.Vector3
+}
+
+func (cmd UseProtoPower) commandType() int { return 12 }
+func (cmd UseProtoPower) fieldValues() map[string]interface{} {
+	return map[string]interface{}{
+		"protoPowerId": cmd.ProtoPowerId,
+		"location1":    cmd.Location1,
+		"location2":    cmd.Location2,
+	}
+}
+
+// MarketBuySellResources sells when Quantity is negative
+type MarketBuySellResources struct {
+	CommandHeader
+	ResourceType int32
+	Quantity     float32
+}
+
+func (cmd MarketBuySellResources) commandType() int { return 13 }
+func (cmd MarketBuySellResources) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"resourceType": cmd.ResourceType, "quantity": cmd.Quantity}
+}
+
+type Ungarrison struct {
+	CommandHeader
+	UnitId int32
+}
+
+func (cmd Ungarrison) commandType() int { return 14 }
+func (cmd Ungarrison) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"unitId": cmd.UnitId}
+}
+
+type Resign struct {
+	CommandHeader
+}
+
+func (cmd Resign) commandType() int                    { return 16 }
+func (cmd Resign) fieldValues() map[string]interface{} { return nil }
+
+// Tribute sends Amount of a resource to Recipient, Tax is the tribute tax rate between 0 and 1
+type Tribute struct {
+	CommandHeader
+	Recipient    int32
+	ResourceType int32
+	Amount       float32
+	Tax          float32
+}
+
+func (cmd Tribute) commandType() int { return 19 }
+func (cmd Tribute) fieldValues() map[string]interface{} {
+	return map[string]interface{}{
+		"recipient":    cmd.Recipient,
+		"resourceType": cmd.ResourceType,
+		"amount":       cmd.Amount,
+		"tax":          cmd.Tax,
+	}
+}
+
+type FinishUnitTransform struct {
+	CommandHeader
+	ProtoUnitId int32
+	UnitId      int32
+}
+
+func (cmd FinishUnitTransform) commandType() int { return 23 }
+func (cmd FinishUnitTransform) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"protoUnitId": cmd.ProtoUnitId, "unitId": cmd.UnitId}
+}
+
+type SetUnitStance struct {
+	CommandHeader
+	Stance int8
+}
+
+func (cmd SetUnitStance) commandType() int { return 25 }
+func (cmd SetUnitStance) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"stance": cmd.Stance}
+}
+
+type ChangeDiplomacy struct {
+	CommandHeader
+	Stance       int8
+	TargetPlayer int32
+}
+
+func (cmd ChangeDiplomacy) commandType() int { return 26 }
+func (cmd ChangeDiplomacy) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"stance": cmd.Stance, "targetPlayer": cmd.TargetPlayer}
+}
+
+type TownBell struct {
+	CommandHeader
+}
+
+func (cmd TownBell) commandType() int                    { return 34 }
+func (cmd TownBell) fieldValues() map[string]interface{} { return nil }
+
+type ChangeControlGroupContents struct {
+	CommandHeader
+	Group     int32
+	UnitId    int32
+	Operation int8
+}
+
+func (cmd ChangeControlGroupContents) commandType() int { return 37 }
+func (cmd ChangeControlGroupContents) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"group": cmd.Group, "unitId": cmd.UnitId, "operation": cmd.Operation}
+}
+
+type Taunt struct {
+	CommandHeader
+	TauntId int32
+}
+
+func (cmd Taunt) commandType() int { return 41 }
+func (cmd Taunt) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"tauntId": cmd.TauntId}
+}
+
+type Cheat struct {
+	CommandHeader
+	CheatId int32
+}
+
+func (cmd Cheat) commandType() int { return 44 }
+func (cmd Cheat) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"cheatId": cmd.CheatId}
+}
+
+type CancelQueuedItem struct {
+	CommandHeader
+	BuildingId int32
+	ItemId     int32
+	QueueSlot  int32
+}
+
+func (cmd CancelQueuedItem) commandType() int { return 45 }
+func (cmd CancelQueuedItem) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"buildingId": cmd.BuildingId, "itemId": cmd.ItemId, "queueSlot": cmd.QueueSlot}
+}
+
+type SetFormation struct {
+	CommandHeader
+	FormationId int32
+}
+
+func (cmd SetFormation) commandType() int { return 48 }
+func (cmd SetFormation) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"formationId": cmd.FormationId}
+}
+
+type StartUnitTransform struct {
+	CommandHeader
+	UnitId      int32
+	ProtoUnitId int32
+}
+
+func (cmd StartUnitTransform) commandType() int { return 53 }
+func (cmd StartUnitTransform) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"unitId": cmd.UnitId, "protoUnitId": cmd.ProtoUnitId}
+}
+
+type Autoqueue struct {
+	CommandHeader
+	ProtoUnitId int32
+}
+
+func (cmd Autoqueue) commandType() int { return 66 }
+func (cmd Autoqueue) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"protoUnitId": cmd.ProtoUnitId}
+}
+
+type ToggleAutoUnitAbility struct {
+	CommandHeader
+	AbilityId int32
+	Enabled   int8
+}
+
+func (cmd ToggleAutoUnitAbility) commandType() int { return 67 }
+func (cmd ToggleAutoUnitAbility) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"abilityId": cmd.AbilityId, "enabled": cmd.Enabled}
+}
+
+type BuildWallConnector struct {
+	CommandHeader
+	ProtoBuildingId int32
+	Start           parser.Vector3
+	End             parser.Vector3
+}
+
+func (cmd BuildWallConnector) commandType() int { return 69 }
+func (cmd BuildWallConnector) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"protoBuildingId": cmd.ProtoBuildingId, "start": cmd.Start, "end": cmd.End}
+}
+
+type SeekShelter struct {
+	CommandHeader
+	BuildingId int32
+}
+
+func (cmd SeekShelter) commandType() int { return 71 }
+func (cmd SeekShelter) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"buildingId": cmd.BuildingId}
+}
+
+type PrequeueTech struct {
+	CommandHeader
+	TechId int32
+}
+
+func (cmd PrequeueTech) commandType() int { return 72 }
+func (cmd PrequeueTech) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"techId": cmd.TechId}
+}
+
+type PrebuyGodPower struct {
+	CommandHeader
+	ProtoPowerId int32
+}
+
+func (cmd PrebuyGodPower) commandType() int { return 75 }
+func (cmd PrebuyGodPower) fieldValues() map[string]interface{} {
+	return map[string]interface{}{"protoPowerId": cmd.ProtoPowerId}
+}
+
+// encodeBody lays the command's field values out with the default layout of its type
+func encodeBody(command Command) ([]byte, error) {
+	commandType := command.commandType()
+	layouts := parser.CurrentLayouts(commandType)
+	if len(layouts) == 0 {
+		return nil, fmt.Errorf("no command layout for type %d", commandType)
+	}
+
+	if raw, ok := command.(RawCommand); ok {
+		for _, layout := range layouts {
+			if layout.ByteLength == len(raw.Body) {
+				return raw.Body, nil
+			}
+		}
+		return nil, fmt.Errorf("no layout of command type %d is %d bytes long", commandType, len(raw.Body))
+	}
+
+	layout := layouts[0]
+	values := command.fieldValues()
+	body := make([]byte, layout.ByteLength)
+
+	written := 0
+	for _, field := range layout.Fields {
+		value, exists := values[field.Name]
+		if !exists || !field.Interpreted {
+			continue
+		}
+		if err := putField(body[field.Offset:field.Offset+field.Size], field, value); err != nil {
+			return nil, fmt.Errorf("command type %d: %w", commandType, err)
+		}
+		written++
+	}
+	// A value without a field means the layout table and the typed command disagree
+	if written != len(values) {
+		return nil, fmt.Errorf("command layout %s doesn't have all of the fields %v", layout.Name, values)
+	}
+	return body, nil
+}
+
+func putField(dest []byte, field parser.CommandField, value interface{}) error {
+	var w byteWriter
+	switch field.Type {
+	case parser.Int8Field:
+		v, ok := value.(int8)
+		if !ok {
+			return fmt.Errorf("field %s is an int8, got %T", field.Name, value)
+		}
+		w.uint8(uint8(v))
+	case parser.Int32Field:
+		v, ok := value.(int32)
+		if !ok {
+			return fmt.Errorf("field %s is an int32, got %T", field.Name, value)
+		}
+		w.int32(v)
+	case parser.FloatField:
+		v, ok := value.(float32)
+		if !ok {
+			return fmt.Errorf("field %s is a float, got %T", field.Name, value)
+		}
+		w.float(v)
+	case parser.VectorField:
+		v, ok := value.(parser.Vector3)
+		if !ok {
+			return fmt.Errorf("field %s is a vector, got %T", field.Name, value)
+		}
+		w.vector(v)
+	default:
+		return fmt.Errorf("field %s has an unknown type %s", field.Name, field.Type)
+	}
+	copy(dest, w.Bytes())
+	return nil
+}
+
+// encodeCommand writes a command the way parser.parseGameCommand reads it
+func encodeCommand(w *byteWriter, command Command) error {
+	header := command.commandHeader()
+	commandType := command.commandType()
+	if header.Player < 0 || header.Player > 12 {
+		return fmt.Errorf("player id must be between 0 and 12, player=%v", header.Player)
+	}
+	body, err := encodeBody(command)
+	if err != nil {
+		return err
+	}
+
+	// The first preamble byte isn't read by the parser
+	w.uint8(0)
+	w.uint8(uint8(commandType))
+	preambleBytes := make([]byte, 8)
+	if commandType == 19 {
+		// Tributes store the player in the preamble, 7 bytes into the command
+		preambleBytes[5] = uint8(header.Player)
+	}
+	w.Write(preambleBytes)
+	if commandType == 14 {
+		w.zeros(20)
+	} else {
+		w.zeros(8)
+	}
+
+	w.uint32(3)
+	if commandType == 19 {
+		w.zeros(4)
+	} else {
+		w.paddedUint16(1)
+		w.paddedUint16(uint16(header.Player))
+	}
+	w.zeros(4)
+
+	w.paddedUint16(uint16(len(header.SourceUnits)))
+	for _, unitId := range header.SourceUnits {
+		w.uint32(unitId)
+	}
+	w.paddedUint16(uint16(len(header.SourceVectors)))
+	for _, vector := range header.SourceVectors {
+		w.vector(vector)
+	}
+
+	// Every command has at least 13 preargument bytes, the first holds the modifier flags
+	preArgumentBytes := make([]byte, 13)
+	if header.Repeat {
+		preArgumentBytes[0] |= repeatModifierFlag
+	}
+	if header.Queued {
+		preArgumentBytes[0] |= queuedModifierFlag
+	}
+	w.paddedUint16(uint16(len(preArgumentBytes) - 13))
+	w.Write(preArgumentBytes)
+
+	w.Write(body)
+	return nil
+}
@@ -0,0 +1,198 @@
+// Package encoder writes synthetic .mythrec replays that parser.Parse reads back, so that the parser can be exercised
+// without playing a game. It writes exactly what the parser reads and nothing more: the files are not meant to be
+// loaded by the game.
+package encoder
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// The outer file holds the command stream uncompressed and the header (the node tree and embedded XMB files) l33t
+// compressed:
+//
+//	0         zero padding
+//	8         "sv" followed by the uint32 offset the command stream is searched from
+//	23        uint32 number of command lists
+//	27        "l33t", the uint32 size of the decompressed header and the zlib compressed header
+//	...       command stream
+const (
+	svOffset           = 8
+	commandCountOffset = 23
+	l33tOffset         = 27
+)
+
+// footerWords is the size of each command list's footer in 4 byte words. The first command list must have a footer of
+// 0x19 words, parser.firstCommandListOffset finds the command stream by looking for it.
+const footerWords = 0x19
+
+// Replay describes a synthetic replay. Commands are written in the command list of their tick, in the order given
+// within a tick. The first command list must be empty and the parser needs at least one command.
+type Replay struct {
+	// BuildString is stored in the FH node, e.g., "AoMRT_s.exe 601511 //stream/Athens/stable"
+	BuildString string
+	MapName     string
+	GameSeed    int32
+	Players     []Player
+	// ProfileKeys are written after the ones derived from the fields above, a key written twice takes the last value
+	ProfileKeys []ProfileKey
+	// Xmbs are embedded under GM/GD, e.g., TechTreeXmb(...)
+	Xmbs       []XmbElement
+	Commands   []Command
+	Selections []Selection
+	// NumTicks is the number of command lists, 0 ends the replay at the last tick with a command or selection
+	NumTicks int
+}
+
+// Selection is the unit selection recorded with a command list, at most 255 units
+type Selection struct {
+	Tick  int
+	Units []uint32
+}
+
+// WriteFile encodes replay and writes it to path, gzipped when isGzip is set
+func WriteFile(path string, replay Replay, isGzip bool) error {
+	data, err := Encode(replay)
+	if err != nil {
+		return err
+	}
+	if isGzip {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(data); err != nil {
+			return err
+		}
+		if err := writer.Close(); err != nil {
+			return err
+		}
+		data = buffer.Bytes()
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func Encode(replay Replay) ([]byte, error) {
+	header, err := encodeHeader(replay)
+	if err != nil {
+		return nil, err
+	}
+	compressedHeader, err := parser.Compressl33t(&header)
+	if err != nil {
+		return nil, err
+	}
+	commandStream, numTicks, err := encodeCommandStream(replay)
+	if err != nil {
+		return nil, err
+	}
+
+	var w byteWriter
+	w.zeros(svOffset)
+	w.WriteString("sv")
+	w.uint32(uint32(l33tOffset + len(compressedHeader)))
+	w.zeros(commandCountOffset - w.Len())
+	w.uint32(uint32(numTicks))
+	w.Write(compressedHeader)
+	w.Write(commandStream)
+	slog.Debug("Encoded replay", "numTicks", numTicks, "size", w.Len())
+	return w.Bytes(), nil
+}
+
+func encodeCommandStream(replay Replay) ([]byte, int, error) {
+	commandsByTick := make(map[int][]Command)
+	numTicks := replay.NumTicks
+	for _, command := range replay.Commands {
+		tick := command.commandHeader().Tick
+		commandsByTick[tick] = append(commandsByTick[tick], command)
+		if replay.NumTicks == 0 && tick > numTicks {
+			numTicks = tick
+		}
+	}
+	selectionsByTick := make(map[int][]uint32)
+	for _, selection := range replay.Selections {
+		if _, exists := selectionsByTick[selection.Tick]; exists {
+			return nil, 0, fmt.Errorf("more than one selection in tick %d", selection.Tick)
+		}
+		units := selection.Units
+		if units == nil {
+			units = []uint32{}
+		}
+		selectionsByTick[selection.Tick] = units
+		if replay.NumTicks == 0 && selection.Tick > numTicks {
+			numTicks = selection.Tick
+		}
+	}
+
+	ticks := make([]int, 0, len(commandsByTick)+len(selectionsByTick))
+	for tick := range commandsByTick {
+		ticks = append(ticks, tick)
+	}
+	for tick := range selectionsByTick {
+		ticks = append(ticks, tick)
+	}
+	sort.Ints(ticks)
+	if len(ticks) > 0 && (ticks[0] < 2 || ticks[len(ticks)-1] > numTicks) {
+		return nil, 0, fmt.Errorf("commands and selections must be in ticks 2 to %d", numTicks)
+	}
+
+	var w byteWriter
+	for tick := 1; tick <= numTicks; tick++ {
+		if err := encodeCommandList(&w, tick, commandsByTick[tick], selectionsByTick[tick]); err != nil {
+			return nil, 0, fmt.Errorf("tick %d: %w", tick, err)
+		}
+	}
+	return w.Bytes(), numTicks, nil
+}
+
+// encodeCommandList writes a command list the way parser.parseCommandList reads it, selection is nil when the tick
+// has no selection
+func encodeCommandList(w *byteWriter, tick int, commands []Command, selection []uint32) error {
+	entryType := uint32(0)
+	if len(commands) > 255 {
+		entryType |= 64
+	} else if len(commands) > 0 {
+		entryType |= 32
+	}
+	if selection != nil {
+		entryType |= 128
+	}
+	w.uint32(entryType)
+	// The early byte and, since bit 1 of the entry type is not set, 4 bytes of padding
+	w.zeros(5)
+
+	if entryType&64 != 0 {
+		w.uint32(uint32(len(commands)))
+	} else if entryType&32 != 0 {
+		w.uint8(uint8(len(commands)))
+	}
+	for _, command := range commands {
+		if err := encodeCommand(w, command); err != nil {
+			return err
+		}
+	}
+
+	if selection != nil {
+		if len(selection) > 255 {
+			return fmt.Errorf("a selection holds at most 255 units, got %d", len(selection))
+		}
+		w.uint8(uint8(len(selection)))
+		for _, unitId := range selection {
+			w.uint32(unitId)
+		}
+	}
+
+	// The footer starts with the number of extra bytes, which the parser skips
+	w.uint8(0)
+	// unk 0 is followed by 8 bytes of padding
+	w.uint8(0)
+	w.zeros(8)
+	w.paddedUint16(footerWords)
+	w.zeros(4 * footerWords)
+	w.uint32(uint32(tick))
+	w.uint8(0)
+	return nil
+}
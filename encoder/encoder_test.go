@@ -0,0 +1,361 @@
+package encoder
+
+import (
+	"encoding/hex"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// testReplay is a two player game with one catalog entry per name the round trip cases refer to
+func testReplay(commands ...Command) Replay {
+	return Replay{
+		BuildString: "AoMRT_s.exe 601511 //stream/Athens/stable",
+		MapName:     "alfheim",
+		GameSeed:    42,
+		Players: []Player{
+			{Num: 1, Name: "alice", ProfileId: "1001", Team: 1, Color: 1, Civ: 1},
+			{Num: 2, Name: "bob", ProfileId: "1002", Team: 2, Color: 2, Civ: 1},
+		},
+		Xmbs: []XmbElement{
+			CivsXmb("Zeus"),
+			ProtoXmb("Villager", "Hoplite", "House", "WallConnector"),
+			TechTreeXmb("ClassicalAge", "Plow"),
+			PowersXmb("Bolt"),
+		},
+		Commands: commands,
+	}
+}
+
+// encodeAndParse writes replay to a temporary file and parses it back with the source units and raw commands
+func encodeAndParse(t *testing.T, replay Replay) parser.ReplayFormatted {
+	t.Helper()
+	replayPath := filepath.Join(t.TempDir(), "roundtrip.mythrec")
+	if err := WriteFile(replayPath, replay, false); err != nil {
+		t.Fatalf("encoding failed: %v", err)
+	}
+	formatted, err := parser.Parse(replayPath, parser.ParseOptions{SourceUnits: true, RawCommands: true})
+	if err != nil {
+		t.Fatalf("parsing failed: %v", err)
+	}
+	return formatted
+}
+
+func header(tick int, sourceUnits ...uint32) CommandHeader {
+	return CommandHeader{Player: 1, Tick: tick, SourceUnits: sourceUnits}
+}
+
+// rawBody78 is a body of the unnamed command type 78, the int32 at offset 12 is its probe
+func rawBody78(probe int32, length int) []byte {
+	var w byteWriter
+	w.zeros(12)
+	w.int32(probe)
+	w.zeros(length - w.Len())
+	return w.Bytes()
+}
+
+func TestCommandRoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		// setup is issued before command, e.g., the train a cancel refers to
+		setup   []Command
+		command Command
+		// commandType and payload are the formatted command, an empty commandType means the command has no formatter
+		commandType string
+		payload     interface{}
+	}{
+		{
+			name: "task",
+			command: Task{
+				CommandHeader:  CommandHeader{Player: 2, Tick: 5, SourceUnits: []uint32{7, 8}, Queued: true},
+				TargetUnitId:   12,
+				TargetLocation: parser.Vector3{X: 10, Y: 0, Z: 20},
+				TargetRange:    2.5,
+			},
+			commandType: "task",
+			payload: parser.TaskPayload{
+				TargetUnitId:   12,
+				TargetLocation: parser.Vector3{X: 10, Y: 0, Z: 20},
+				Range:          2.5,
+				Queued:         true,
+				SourceUnits:    []uint32{7, 8},
+			},
+		},
+		{
+			name:        "research",
+			command:     Research{CommandHeader: header(5, 100), TechId: 1},
+			commandType: "research",
+			payload:     "Plow",
+		},
+		{
+			name:        "train",
+			command:     Train{CommandHeader: header(5, 100), ProtoUnitId: 1, NumUnits: 5},
+			commandType: "train",
+			payload:     "Hoplite",
+		},
+		{
+			name: "build",
+			command: Build{
+				CommandHeader:   CommandHeader{Player: 1, Tick: 5, SourceUnits: []uint32{7}, Queued: true},
+				ProtoBuildingId: 2,
+				Location:        parser.Vector3{X: 30, Y: 1, Z: 40},
+			},
+			commandType: "build",
+			payload:     parser.BuildCommandPaylod{Name: "House", Location: parser.Vector3{X: 30, Y: 1, Z: 40}, Queued: true},
+		},
+		{
+			name: "setGatherPoint",
+			command: SetGatherPoint{
+				CommandHeader: header(5, 100),
+				TargetUnitId:  -1,
+				Location:      parser.Vector3{X: 5, Y: 0, Z: 6},
+				TargetRange:   1,
+			},
+			commandType: "setGatherPoint",
+			payload: parser.SetGatherPointPayload{
+				TargetUnitId: -1,
+				Location:     parser.Vector3{X: 5, Y: 0, Z: 6},
+				Range:        1,
+				Buildings:    []uint32{100},
+			},
+		},
+		{
+			name:        "delete",
+			command:     Delete{CommandHeader: header(5, 7), UnitId: 9},
+			commandType: "delete",
+			payload:     parser.DeletePayload{Units: []parser.DeletedUnit{{UnitId: 7, Proto: "unknown"}, {UnitId: 9, Proto: "unknown"}}},
+		},
+		{
+			name:    "stop",
+			command: Stop{CommandHeader: header(5, 7)},
+		},
+		{
+			name: "useProtoPower",
+			command: UseProtoPower{
+				CommandHeader: header(5),
+				ProtoPowerId:  0,
+				Location1:     parser.Vector3{X: 1, Y: 2, Z: 3},
+				Location2:     parser.Vector3{X: 4, Y: 5, Z: 6},
+			},
+			commandType: "protoPower",
+			payload: parser.ProtoPowerPayload{
+				Name:      "Bolt",
+				Location1: parser.Vector3{X: 1, Y: 2, Z: 3},
+				Location2: parser.Vector3{X: 4, Y: 5, Z: 6},
+			},
+		},
+		{
+			name:        "marketBuySellResources",
+			command:     MarketBuySellResources{CommandHeader: header(5), ResourceType: 1, Quantity: -100},
+			commandType: "marketBuySell",
+			payload:     parser.BuySellResourcesPayload{ResourceType: "wood", Action: "sell", Quantity: 100},
+		},
+		{
+			name:        "ungarrison",
+			command:     Ungarrison{CommandHeader: header(5, 100), UnitId: -1},
+			commandType: "ungarrison",
+			payload:     parser.UngarrisonPayload{UnitId: -1, All: true, Buildings: []uint32{100}},
+		},
+		{
+			name:        "resign",
+			command:     Resign{CommandHeader: header(5)},
+			commandType: "resign",
+			payload:     "1",
+		},
+		{
+			name: "tribute",
+			command: Tribute{
+				CommandHeader: header(5),
+				Recipient:     2,
+				ResourceType:  0,
+				Amount:        200,
+				Tax:           0.2,
+			},
+			commandType: "tribute",
+			payload:     parser.TributePayload{Recipient: 2, ResourceType: "gold", Amount: 200, Tax: 0.2},
+		},
+		{
+			name:        "finishUnitTransform",
+			command:     FinishUnitTransform{CommandHeader: header(5, 7), ProtoUnitId: 1, UnitId: 7},
+			commandType: "transform",
+			payload:     parser.TransformPayload{UnitId: 7, From: "unknown", To: "Hoplite", Finished: true},
+		},
+		{
+			name:        "setUnitStance",
+			command:     SetUnitStance{CommandHeader: header(5, 7), Stance: 2},
+			commandType: "stance",
+			payload:     "standGround",
+		},
+		{
+			name:        "changeDiplomacy",
+			command:     ChangeDiplomacy{CommandHeader: header(5), Stance: 2, TargetPlayer: 2},
+			commandType: "diplomacy",
+			payload:     parser.DiplomacyPayload{TargetPlayer: 2, Stance: "enemy"},
+		},
+		{
+			name:        "townBell",
+			command:     TownBell{CommandHeader: header(5)},
+			commandType: "townBell",
+			payload:     "1",
+		},
+		{
+			name:        "changeControlGroupContents",
+			command:     ChangeControlGroupContents{CommandHeader: header(5, 7), Group: 3, UnitId: 8, Operation: 1},
+			commandType: "controlGroup",
+			payload:     parser.ControlGroupPayload{Group: 3, Operation: "add", UnitIds: []uint32{7, 8}},
+		},
+		{
+			name:        "taunt",
+			command:     Taunt{CommandHeader: header(5), TauntId: 14},
+			commandType: "taunt",
+			payload:     "14",
+		},
+		{
+			name:        "cheat",
+			command:     Cheat{CommandHeader: header(5), CheatId: 3},
+			commandType: "cheat",
+			payload:     "3",
+		},
+		{
+			name:        "cancelQueuedItem",
+			setup:       []Command{Train{CommandHeader: header(3, 100), ProtoUnitId: 1}},
+			command:     CancelQueuedItem{CommandHeader: header(5, 100), BuildingId: 100, ItemId: 1, QueueSlot: 0},
+			commandType: "cancelQueuedItem",
+			payload:     parser.CancelQueuedItemPayload{Name: "Hoplite", Kind: "unit", BuildingId: 100, QueueSlot: 0},
+		},
+		{
+			name:        "cancelQueuedItem without a queued item",
+			command:     CancelQueuedItem{CommandHeader: header(5, 100), BuildingId: 100, ItemId: 1, QueueSlot: 2},
+			commandType: "cancelQueuedItem",
+			payload:     parser.CancelQueuedItemPayload{Name: "unknown", Kind: "unknown", BuildingId: 100, QueueSlot: 2},
+		},
+		{
+			name:        "setFormation",
+			command:     SetFormation{CommandHeader: header(5, 7, 8), FormationId: 1},
+			commandType: "setFormation",
+			payload:     "box",
+		},
+		{
+			name:        "startUnitTransform",
+			command:     StartUnitTransform{CommandHeader: header(5, 7), UnitId: 7, ProtoUnitId: 1},
+			commandType: "transform",
+			payload:     parser.TransformPayload{UnitId: 7, From: "unknown", To: "Hoplite", Finished: false},
+		},
+		{
+			name:        "autoqueue",
+			command:     Autoqueue{CommandHeader: header(5, 100), ProtoUnitId: 0},
+			commandType: "autoqueue",
+			payload:     "Villager",
+		},
+		{
+			name:        "toggleAutoUnitAbility",
+			command:     ToggleAutoUnitAbility{CommandHeader: header(5, 7), AbilityId: 4, Enabled: 1},
+			commandType: "toggleAutoAbility",
+			payload:     parser.ToggleAutoAbilityPayload{Unit: "unknown", AbilityId: 4, Enabled: true},
+		},
+		{
+			name: "buildWallConnector",
+			command: BuildWallConnector{
+				CommandHeader:   header(5, 7),
+				ProtoBuildingId: 3,
+				Start:           parser.Vector3{X: 0, Y: 0, Z: 0},
+				End:             parser.Vector3{X: 3, Y: 0, Z: 4},
+			},
+			commandType: "buildWall",
+			payload: parser.BuildWallPayload{
+				Name:   "WallConnector",
+				Start:  parser.Vector3{X: 0, Y: 0, Z: 0},
+				End:    parser.Vector3{X: 3, Y: 0, Z: 4},
+				Length: 5,
+			},
+		},
+		{
+			name:        "seekShelter",
+			command:     SeekShelter{CommandHeader: header(5, 7, 8, 9), BuildingId: 100},
+			commandType: "seekShelter",
+			payload:     parser.SeekShelterPayload{BuildingId: 100, NumUnits: 3},
+		},
+		{
+			name:        "prequeueTech",
+			command:     PrequeueTech{CommandHeader: header(5, 100), TechId: 0},
+			commandType: "prequeueTech",
+			payload:     "ClassicalAge",
+		},
+		{
+			name:        "prebuyGodPower",
+			command:     PrebuyGodPower{CommandHeader: header(5), ProtoPowerId: 0},
+			commandType: "prebuyGodPower",
+			payload:     "Bolt",
+		},
+		{
+			name:    "type 78",
+			command: RawCommand{CommandHeader: header(5, 7), Type: 78, Body: rawBody78(1, 20)},
+		},
+		{
+			name:    "type 78 with a vector",
+			command: RawCommand{CommandHeader: header(5, 7), Type: 78, Body: rawBody78(3, 28)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			formatted := encodeAndParse(t, testReplay(append(test.setup, test.command)...))
+			commandHeader := test.command.commandHeader()
+
+			rawCommands := *formatted.RawCommands
+			if len(rawCommands) != len(test.setup)+1 {
+				t.Fatalf("parsed %d commands, want %d", len(rawCommands), len(test.setup)+1)
+			}
+			raw := rawCommands[len(rawCommands)-1]
+			if raw.CommandType != test.command.commandType() {
+				t.Errorf("command type = %d, want %d", raw.CommandType, test.command.commandType())
+			}
+			if raw.PlayerNum != commandHeader.Player || raw.Tick != commandHeader.Tick {
+				t.Errorf("player %d at tick %d, want player %d at tick %d",
+					raw.PlayerNum, raw.Tick, commandHeader.Player, commandHeader.Tick)
+			}
+			if !reflect.DeepEqual(raw.SourceUnits, commandHeader.SourceUnits) &&
+				(len(raw.SourceUnits) != 0 || len(commandHeader.SourceUnits) != 0) {
+				t.Errorf("source units = %v, want %v", raw.SourceUnits, commandHeader.SourceUnits)
+			}
+			body, err := encodeBody(test.command)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if raw.Body != hex.EncodeToString(body) {
+				t.Errorf("body = %s, want %s", raw.Body, hex.EncodeToString(body))
+			}
+
+			var gameCommands []parser.ReplayGameCommand
+			if formatted.GameCommands != nil {
+				gameCommands = *formatted.GameCommands
+			}
+			if test.commandType == "" {
+				if len(gameCommands) != len(test.setup) {
+					t.Errorf("command type %d was formatted, it has no formatter", raw.CommandType)
+				}
+				return
+			}
+			if len(gameCommands) != len(test.setup)+1 {
+				t.Fatalf("formatted %d commands, want %d", len(gameCommands), len(test.setup)+1)
+			}
+			gameCommand := gameCommands[len(gameCommands)-1]
+			if gameCommand.CommandType != test.commandType {
+				t.Errorf("formatted command type = %s, want %s", gameCommand.CommandType, test.commandType)
+			}
+			if !reflect.DeepEqual(gameCommand.Payload, test.payload) {
+				t.Errorf("payload = %#v, want %#v", gameCommand.Payload, test.payload)
+			}
+			modifiers := parser.CommandModifiers{}
+			if gameCommand.Modifiers != nil {
+				modifiers = *gameCommand.Modifiers
+			}
+			if modifiers.Queued != commandHeader.Queued || modifiers.Repeat != commandHeader.Repeat {
+				t.Errorf("modifiers = %+v, want queued %v and repeat %v",
+					modifiers, commandHeader.Queued, commandHeader.Repeat)
+			}
+		})
+	}
+}
@@ -0,0 +1,146 @@
+package encoder
+
+import (
+	"fmt"
+)
+
+// The header is the node tree parser.parseHeader walks: a two letter token, the uint32 size of the node's data and
+// the data itself, which for the tokens in parser.NODES_WITH_SUBSTRUCTURE is a run of child nodes. The parser finds
+// children by scanning for two letter tokens, so nodes are written back to back without any bytes in between.
+
+// Profile key types, see parser.KEYTYPE_PARSE_MAP
+const (
+	int32KeyType  int32 = 1
+	int16KeyType  int32 = 4
+	boolKeyType   int32 = 6
+	stringKeyType int32 = 10
+)
+
+type node struct {
+	token    string
+	data     []byte
+	children []*node
+}
+
+func (n *node) encode(w *byteWriter) {
+	w.WriteString(n.token)
+	sizeOffset := w.Len()
+	w.uint32(0)
+	start := w.Len()
+	if n.children == nil {
+		w.Write(n.data)
+	} else {
+		for _, child := range n.children {
+			child.encode(w)
+		}
+	}
+	w.putUint32(sizeOffset, uint32(w.Len()-start))
+}
+
+// ProfileKey is a key of the MP/ST node. Value must be a string, int32, int16 or bool, the key type is derived from
+// it.
+type ProfileKey struct {
+	Name  string
+	Value interface{}
+}
+
+// Player is written as the gameplayer<Num>* profile keys the parser reads players from. Civ is the index of the
+// player's major god in the civs XMB plus one (0 is Nature) and ProfileId is empty for AI players.
+type Player struct {
+	Num          int
+	Name         string
+	ProfileId    string
+	Team         int32
+	Color        int32
+	Civ          int32
+	CivWasRandom bool
+	CivList      string
+}
+
+func (player Player) profileKeys() []ProfileKey {
+	prefix := fmt.Sprintf("gameplayer%d", player.Num)
+	return []ProfileKey{
+		{prefix + "name", player.Name},
+		{prefix + "rlinkid", player.ProfileId},
+		{prefix + "teamid", player.Team},
+		{prefix + "color", player.Color},
+		{prefix + "civ", player.Civ},
+		{prefix + "civwasrandom", player.CivWasRandom},
+		{prefix + "civlist", player.CivList},
+	}
+}
+
+func encodeHeader(replay Replay) ([]byte, error) {
+	var fh byteWriter
+	fh.string(replay.BuildString)
+
+	profileKeys := []ProfileKey{
+		{"gamemapname", replay.MapName},
+		{"gamerandomseed", replay.GameSeed},
+	}
+	for _, player := range replay.Players {
+		if player.Num < 1 || player.Num > 12 {
+			return nil, fmt.Errorf("player number must be between 1 and 12, num=%v", player.Num)
+		}
+		profileKeys = append(profileKeys, player.profileKeys()...)
+	}
+	profileKeys = append(profileKeys, replay.ProfileKeys...)
+	st, err := encodeProfileKeys(profileKeys)
+	if err != nil {
+		return nil, err
+	}
+
+	xmbNodes := make([]*node, 0, len(replay.Xmbs))
+	for _, xmb := range replay.Xmbs {
+		xmbBytes, err := encodeXmb(xmb)
+		if err != nil {
+			return nil, err
+		}
+		// A gd node holds an unknown byte, the number of XMB files and the XMB itself, see parser.parseXmbMap
+		var gd byteWriter
+		gd.uint8(0)
+		gd.uint32(1)
+		gd.Write(xmbBytes)
+		xmbNodes = append(xmbNodes, &node{token: "gd", data: gd.Bytes()})
+	}
+
+	root := &node{token: "BG", children: []*node{
+		{token: "FH", data: fh.Bytes()},
+		{token: "MP", children: []*node{{token: "ST", data: st}}},
+		{token: "GM", children: []*node{{token: "GD", children: xmbNodes}}},
+	}}
+	var w byteWriter
+	root.encode(&w)
+	return w.Bytes(), nil
+}
+
+func encodeProfileKeys(profileKeys []ProfileKey) ([]byte, error) {
+	var w byteWriter
+	// The key count is preceded by 4 null padding bytes, see parser.parseProfileKeys
+	w.zeros(4)
+	w.int32(int32(len(profileKeys)))
+	for _, key := range profileKeys {
+		w.string(key.Name)
+		switch value := key.Value.(type) {
+		case string:
+			w.int32(stringKeyType)
+			w.string(value)
+		case int32:
+			w.int32(int32KeyType)
+			w.int32(value)
+		case int16:
+			w.int32(int16KeyType)
+			w.uint16(uint16(value))
+		case bool:
+			w.int32(boolKeyType)
+			if value {
+				w.uint8(1)
+			} else {
+				w.uint8(0)
+			}
+		default:
+			return nil, fmt.Errorf("profile key %s has an unsupported value type %T", key.Name, key.Value)
+		}
+	}
+	return w.Bytes(), nil
+}
@@ -0,0 +1,66 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"unicode/utf16"
+
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// byteWriter is the write side of the read* helpers in parser/decoder.go, everything is little endian
+type byteWriter struct {
+	bytes.Buffer
+}
+
+func (w *byteWriter) uint8(value uint8) {
+	w.WriteByte(value)
+}
+
+func (w *byteWriter) uint16(value uint16) {
+	w.Write(binary.LittleEndian.AppendUint16(nil, value))
+}
+
+func (w *byteWriter) uint32(value uint32) {
+	w.Write(binary.LittleEndian.AppendUint32(nil, value))
+}
+
+func (w *byteWriter) int32(value int32) {
+	w.uint32(uint32(value))
+}
+
+func (w *byteWriter) float(value float32) {
+	w.uint32(math.Float32bits(value))
+}
+
+func (w *byteWriter) vector(value parser.Vector3) {
+	w.int32(value.X)
+	w.int32(value.Y)
+	w.int32(value.Z)
+}
+
+// paddedUint16 writes a uint16 followed by 2 null padding bytes, the replay stores most counts this way
+func (w *byteWriter) paddedUint16(value uint16) {
+	w.uint16(value)
+	w.uint16(0)
+}
+
+// string writes a string the way parser.readString reads it: the number of UTF-16 characters, 2 null padding bytes
+// and the UTF-16 little endian characters
+func (w *byteWriter) string(value string) {
+	u16s := utf16.Encode([]rune(value))
+	w.paddedUint16(uint16(len(u16s)))
+	for _, u16 := range u16s {
+		w.uint16(u16)
+	}
+}
+
+func (w *byteWriter) zeros(count int) {
+	w.Write(make([]byte, count))
+}
+
+// putUint32 overwrites 4 bytes at offset, used to fill in sizes once the data they cover is written
+func (w *byteWriter) putUint32(offset int, value uint32) {
+	binary.LittleEndian.PutUint32(w.Bytes()[offset:offset+4], value)
+}
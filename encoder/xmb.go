@@ -0,0 +1,130 @@
+package encoder
+
+import (
+	"fmt"
+)
+
+// XmbElement is an element of an XMB file embedded in the replay. The parser looks XMB files up by the name of their
+// root element, e.g., "techtree" or "proto".
+type XmbElement struct {
+	Name       string
+	Value      string
+	Attributes []XmbAttribute
+	Children   []XmbElement
+}
+
+type XmbAttribute struct {
+	Name  string
+	Value string
+}
+
+// catalogXmb builds an XMB whose ids are the indices of names, which is how commands refer to techs, units and powers
+func catalogXmb(rootName string, elementName string, names []string) XmbElement {
+	root := XmbElement{Name: rootName, Children: make([]XmbElement, 0, len(names))}
+	for _, name := range names {
+		root.Children = append(root.Children, XmbElement{
+			Name:       elementName,
+			Attributes: []XmbAttribute{{"name", name}},
+		})
+	}
+	return root
+}
+
+// ProtoXmb builds a proto XMB, protoUnitId n is names[n]
+func ProtoXmb(names ...string) XmbElement {
+	return catalogXmb("proto", "unit", names)
+}
+
+// TechTreeXmb builds a techtree XMB, techId n is names[n]
+func TechTreeXmb(names ...string) XmbElement {
+	return catalogXmb("techtree", "tech", names)
+}
+
+// PowersXmb builds a powers XMB, protoPowerId n is names[n]
+func PowersXmb(names ...string) XmbElement {
+	return catalogXmb("powers", "power", names)
+}
+
+// CivsXmb builds a civs XMB, the major god of a player with Civ n is gods[n-1]
+func CivsXmb(gods ...string) XmbElement {
+	root := XmbElement{Name: "civs", Children: make([]XmbElement, 0, len(gods))}
+	for _, god := range gods {
+		root.Children = append(root.Children, XmbElement{
+			Name:     "civ",
+			Children: []XmbElement{{Name: "name", Value: god}},
+		})
+	}
+	return root
+}
+
+type xmbNames struct {
+	names   []string
+	indices map[string]uint32
+}
+
+func (table *xmbNames) add(name string) {
+	if _, exists := table.indices[name]; exists {
+		return
+	}
+	table.indices[name] = uint32(len(table.names))
+	table.names = append(table.names, name)
+}
+
+func (table *xmbNames) collect(element XmbElement, attributes *xmbNames) {
+	table.add(element.Name)
+	for _, attribute := range element.Attributes {
+		attributes.add(attribute.Name)
+	}
+	for _, child := range element.Children {
+		table.collect(child, attributes)
+	}
+}
+
+// encodeXmb writes an X1 XMB file the way parser.parseXmb reads it. The root element is the first entry of the
+// element table, parser.parseXmbMap reads the file's name from there.
+func encodeXmb(root XmbElement) ([]byte, error) {
+	if root.Name == "" {
+		return nil, fmt.Errorf("XMB root element has no name")
+	}
+	elements := xmbNames{indices: make(map[string]uint32)}
+	attributes := xmbNames{indices: make(map[string]uint32)}
+	elements.collect(root, &attributes)
+
+	var w byteWriter
+	w.WriteString("X1")
+	sizeOffset := w.Len()
+	w.uint32(0)
+	w.WriteString("XR")
+	w.uint32(4)
+	w.uint32(8)
+	w.uint32(uint32(len(elements.names)))
+	for _, name := range elements.names {
+		w.string(name)
+	}
+	w.uint32(uint32(len(attributes.names)))
+	for _, name := range attributes.names {
+		w.string(name)
+	}
+	encodeXmbNode(&w, root, &elements, &attributes)
+	w.putUint32(sizeOffset, uint32(w.Len()-sizeOffset-4))
+	return w.Bytes(), nil
+}
+
+func encodeXmbNode(w *byteWriter, element XmbElement, elements *xmbNames, attributes *xmbNames) {
+	w.WriteString("XN")
+	sizeOffset := w.Len()
+	w.uint32(0)
+	w.string(element.Value)
+	w.uint32(elements.indices[element.Name])
+	w.zeros(4)
+	w.uint32(uint32(len(element.Attributes)))
+	for _, attribute := range element.Attributes {
+		w.uint32(attributes.indices[attribute.Name])
+		w.string(attribute.Value)
+	}
+	w.uint32(uint32(len(element.Children)))
+	for _, child := range element.Children {
+		encodeXmbNode(w, child, elements, attributes)
+	}
+	w.putUint32(sizeOffset, uint32(w.Len()-sizeOffset-4))
+}
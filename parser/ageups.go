@@ -0,0 +1,187 @@
+package parser
+
+import (
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Age-up timings are reconstructed from the research and prequeueTech commands for the age-up techs
+// (ClassicalAge*, HeroicAge*, MythicAge*). The replay only records when the player clicked, so the completion time is
+// an estimate: the research starts at the click (or when the previous age finishes, whichever is later) and takes the
+// tech's researchpoints from the techtree XMB. Wonder age is not researched, it is granted when a Wonder finishes, so
+// it is estimated from the first Wonder placement plus the Wonder's buildpoints from the proto XMB. A click is cancelled
+// when resolveCancelledItems linked a cancel to it, the same links the production ledger uses.
+
+var ageUpOrder = []string{"Classical", "Heroic", "Mythic"}
+
+type ageUpClick struct {
+	AgeUpItem
+	cancelled bool
+}
+
+// techNode resolves a techId to its techtree entry, returning nil rather than panicking for ids outside the tree.
+func techNode(node *XmbNode, id int32) *XmbNode {
+	if node == nil || int(id) < 0 || int(id) >= len(node.children) {
+		return nil
+	}
+	return node.children[id]
+}
+
+// protoNode resolves a protoUnitId to its proto entry, see protoName.
+func protoNode(node *XmbNode, id int32) *XmbNode {
+	if node == nil || int(id) < 0 || int(id) >= len(node.children) {
+		return nil
+	}
+	return node.children[id]
+}
+
+// xmbChildFloat reads the float value of the child element with the given name, returning 0 if it is missing.
+func xmbChildFloat(node *XmbNode, elementName string) float64 {
+	child := findXmbChild(node, elementName)
+	if child == nil {
+		return 0
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(child.value), 64)
+	if err != nil {
+		slog.Debug("Could not parse XMB float", "elementName", elementName, "value", child.value)
+		return 0
+	}
+	return value
+}
+
+// ageOfTech returns the age (Classical, Heroic, Mythic) an age-up tech advances to, or "" if the tech isn't a real
+// age-up. Placeholder techs whose suffix is a major god or civ are skipped, see nonMinorGodAgeUpSuffixes.
+func ageOfTech(tech string) string {
+	for _, age := range ageUpOrder {
+		prefix := age + "Age"
+		if strings.HasPrefix(tech, prefix) {
+			if isNonMinorGodSuffix(strings.TrimPrefix(tech, prefix)) {
+				return ""
+			}
+			return age
+		}
+	}
+	return ""
+}
+
+func getAgeUps(
+	playerNum int,
+	commandList *[]RawGameCommand,
+	techTreeRootNode *XmbNode,
+	protoRootNode *XmbNode,
+) []AgeUpItem {
+	clicks := make([]*ageUpClick, 0)
+	// clicksByCommandIdx finds the click a resolved cancel removed, see resolveCancelledItems
+	clicksByCommandIdx := make(map[int]*ageUpClick)
+	var wonder *AgeUpItem
+
+	for i, command := range *commandList {
+		if command.PlayerId() != playerNum {
+			continue
+		}
+
+		switch cmd := command.(type) {
+		case ResearchCommand:
+			if click := newAgeUpClick(techTreeRootNode, cmd.techId, cmd.BaseCommand, false); click != nil {
+				clicks = append(clicks, click)
+				clicksByCommandIdx[i] = click
+			}
+		case PrequeueTechCommand:
+			if click := newAgeUpClick(techTreeRootNode, cmd.techId, cmd.BaseCommand, true); click != nil {
+				clicks = append(clicks, click)
+				clicksByCommandIdx[i] = click
+			}
+		case CancelQueuedItemCommand:
+			if click, exists := clicksByCommandIdx[cmd.cancelledCommandIdx]; exists {
+				slog.Debug("Age up cancelled", "tech", click.Name, "gameTimeSecs", cmd.GameTimeSecs())
+				click.cancelled = true
+			}
+		case BuildCommand:
+			if wonder == nil && protoName(protoRootNode, cmd.protoBuildingId) == "Wonder" {
+				buildSecs := xmbChildFloat(protoNode(protoRootNode, cmd.protoBuildingId), "buildpoints")
+				wonder = &AgeUpItem{
+					Age:             "Wonder",
+					Name:            "Wonder",
+					ClickedAtSecs:   cmd.GameTimeSecs(),
+					DurationSecs:    buildSecs,
+					CompletedAtSecs: cmd.GameTimeSecs() + buildSecs,
+				}
+			}
+		}
+	}
+
+	// The first click that wasn't cancelled is the one that advanced the player, later clicks on the same age can
+	// only be duplicates the game rejected.
+	byAge := make(map[string]AgeUpItem)
+	for _, click := range clicks {
+		if click.cancelled {
+			continue
+		}
+		if _, exists := byAge[click.Age]; !exists {
+			byAge[click.Age] = click.AgeUpItem
+		}
+	}
+
+	ageUps := make([]AgeUpItem, 0)
+	previousCompletion := 0.0
+	for _, age := range ageUpOrder {
+		item, exists := byAge[age]
+		if !exists {
+			// Ages can't be skipped, so there is nothing to estimate past the first missing one
+			break
+		}
+		start := item.ClickedAtSecs
+		if previousCompletion > start {
+			start = previousCompletion
+		}
+		item.CompletedAtSecs = start + item.DurationSecs
+		previousCompletion = item.CompletedAtSecs
+		ageUps = append(ageUps, item)
+	}
+
+	if wonder != nil {
+		ageUps = append(ageUps, *wonder)
+	}
+	sort.SliceStable(ageUps, func(i, j int) bool {
+		return ageUps[i].CompletedAtSecs < ageUps[j].CompletedAtSecs
+	})
+
+	slog.Debug("Age ups", "playerNum", playerNum, "ageUps", ageUps)
+	return ageUps
+}
+
+// newAgeUpClick returns the age-up a research or prequeue click started, or nil if the tech isn't an age-up
+func newAgeUpClick(techTreeRootNode *XmbNode, techId int32, baseCommand BaseCommand, prequeued bool) *ageUpClick {
+	node := techNode(techTreeRootNode, techId)
+	if node == nil {
+		return nil
+	}
+	tech := node.attributes["name"]
+	age := ageOfTech(tech)
+	if age == "" {
+		return nil
+	}
+	return &ageUpClick{
+		AgeUpItem: AgeUpItem{
+			Age:           age,
+			Name:          tech,
+			Prequeued:     prequeued,
+			ClickedAtSecs: baseCommand.GameTimeSecs(),
+			DurationSecs:  xmbChildFloat(node, "researchpoints"),
+		},
+	}
+}
+
+func addAgeUpsToPlayers(
+	players *[]ReplayPlayer,
+	commandList *[]RawGameCommand,
+	techTreeRootNode *XmbNode,
+	protoRootNode *XmbNode,
+) {
+	slog.Debug("Adding age ups to players")
+	for i := range *players {
+		(*players)[i].AgeUps = getAgeUps((*players)[i].PlayerNum, commandList, techTreeRootNode, protoRootNode)
+	}
+}
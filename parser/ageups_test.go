@@ -0,0 +1,85 @@
+package parser_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// Tech ids of the age-ups in testReplay
+const (
+	classicalAge = 0
+	heroicAge    = 2
+)
+
+func TestAgeUps(t *testing.T) {
+	type ageUp struct {
+		Age             string
+		Prequeued       bool
+		ClickedAtSecs   float64
+		CompletedAtSecs float64
+	}
+	tests := []struct {
+		name     string
+		commands []encoder.Command
+		want     []ageUp
+	}{
+		{
+			name: "research and prequeue",
+			commands: []encoder.Command{
+				encoder.Research{CommandHeader: at(1, 200, 100), TechId: classicalAge},
+				// Prequeued before Classical is done, so Heroic starts when Classical completes
+				encoder.PrequeueTech{CommandHeader: at(1, 400), TechId: heroicAge},
+			},
+			want: []ageUp{
+				{Age: "Classical", ClickedAtSecs: 10, CompletedAtSecs: 70},
+				{Age: "Heroic", Prequeued: true, ClickedAtSecs: 20, CompletedAtSecs: 130},
+			},
+		},
+		{
+			name: "a cancelled click doesn't advance",
+			commands: []encoder.Command{
+				encoder.Research{CommandHeader: at(1, 200, 100), TechId: classicalAge},
+				encoder.CancelQueuedItem{CommandHeader: at(1, 300, 100), BuildingId: 100, ItemId: classicalAge},
+				encoder.Research{CommandHeader: at(1, 400, 100), TechId: classicalAge},
+			},
+			want: []ageUp{{Age: "Classical", ClickedAtSecs: 20, CompletedAtSecs: 80}},
+		},
+		{
+			name: "cancelling a unit with the age-up's id leaves the age-up",
+			commands: []encoder.Command{
+				encoder.Research{CommandHeader: at(1, 200, 100), TechId: classicalAge},
+				encoder.Train{CommandHeader: at(1, 220, 100), ProtoUnitId: classicalAge},
+				encoder.CancelQueuedItem{CommandHeader: at(1, 240, 100), BuildingId: 100, ItemId: classicalAge},
+			},
+			want: []ageUp{{Age: "Classical", ClickedAtSecs: 10, CompletedAtSecs: 70}},
+		},
+		{
+			name: "a cancel in another building leaves the age-up",
+			commands: []encoder.Command{
+				encoder.Research{CommandHeader: at(1, 200, 100), TechId: classicalAge},
+				encoder.CancelQueuedItem{CommandHeader: at(1, 300, 101), BuildingId: 101, ItemId: classicalAge},
+			},
+			want: []ageUp{{Age: "Classical", ClickedAtSecs: 10, CompletedAtSecs: 70}},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replay := testReplay(append(test.commands, encoder.Resign{CommandHeader: at(2, 2000)})...)
+			formatted, err := parser.Parse(writeReplay(t, replay), parser.ParseOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			ageUps := make([]ageUp, 0)
+			for _, item := range formatted.Players[0].AgeUps {
+				ageUps = append(ageUps, ageUp{item.Age, item.Prequeued, item.ClickedAtSecs, item.CompletedAtSecs})
+			}
+			if !reflect.DeepEqual(ageUps, test.want) {
+				t.Errorf("age ups = %+v, want %+v", ageUps, test.want)
+			}
+		})
+	}
+}
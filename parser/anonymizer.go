@@ -0,0 +1,283 @@
+package parser
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Anonymizing rewrites the player names and rlinkid profile ids in the MP/ST profile keys of the decompressed header.
+// The new strings rarely have the same length as the old ones, so the sizes of the header nodes containing them are
+// fixed up, the header is l33t compressed again and the offset of the command stream (stored after the "sv" bytes of
+// the outer buffer) is moved by however much the compressed header grew or shrank. Everything else is copied byte for
+// byte.
+
+// playerAliasFormat is the name given to players without a user-supplied alias
+const playerAliasFormat = "Player%d"
+
+// ErrResidualNames is returned by AnonymizeReplay when a player name or profile id is still in the anonymized replay
+var ErrResidualNames = errors.New("player names or profile ids are still in the replay outside of the profile keys")
+
+// stringEdit replaces the string (length prefix included) at data[offset:endOffset]
+type stringEdit struct {
+	offset    int
+	endOffset int
+	value     string
+}
+
+// AnonymizeReplay writes an anonymized copy of a replay to outputPath. aliases maps player names to the name they
+// should get, players without an alias are named "Player<num>". Profile ids are replaced by the negated player number,
+// real profile ids are never negative so the fake ones can't be mistaken for an account. Any other string profile key
+// holding a player's name or profile id is rewritten the same way. When a player name (UTF-16) or profile id (ASCII or
+// UTF-16) is still found elsewhere in the replay nothing is written and ErrResidualNames is returned, unless
+// allowResidual is set.
+func AnonymizeReplay(
+	replayPath string,
+	outputPath string,
+	aliases map[string]string,
+	allowResidual bool,
+	isGzip bool,
+) ([]PlayerAlias, error) {
+	rawData, err := readRawData(replayPath, isGzip)
+	if err != nil {
+		return nil, err
+	}
+	data, l33tOffset, l33tEndOffset, err := decompressl33tSection(&rawData)
+	if err != nil {
+		return nil, err
+	}
+	rootNode := parseHeader(&data)
+	profileKeys, err := parseProfileKeys(&data, rootNode)
+	if err != nil {
+		return nil, err
+	}
+
+	playerAliases := make([]PlayerAlias, 0)
+	replacements := make(map[string]string)
+	for playerNum := 1; playerNum <= 12; playerNum++ {
+		if !playerExists(&profileKeys, playerNum) {
+			continue
+		}
+		playerPrefix := fmt.Sprintf("gameplayer%d", playerNum)
+		playerAlias := PlayerAlias{
+			PlayerNum: playerNum,
+			Name:      profileKeys[playerPrefix+"name"].StringVal,
+			Alias:     fmt.Sprintf(playerAliasFormat, playerNum),
+			ProfileId: profileKeys[playerPrefix+"rlinkid"].StringVal,
+		}
+		if alias, exists := aliases[playerAlias.Name]; exists {
+			playerAlias.Alias = alias
+		}
+		// AI players have no profile id, leave it empty so that they still look like AI
+		if playerAlias.ProfileId != "" {
+			playerAlias.AliasProfileId = strconv.Itoa(-playerNum)
+			replacements[playerAlias.ProfileId] = playerAlias.AliasProfileId
+		}
+		replacements[playerAlias.Name] = playerAlias.Alias
+		playerAliases = append(playerAliases, playerAlias)
+	}
+	for name := range aliases {
+		if _, exists := replacements[name]; !exists {
+			slog.Warn("Alias doesn't match any player", "name", name)
+		}
+	}
+
+	edits := make([]stringEdit, 0)
+	for keyname, profileKey := range profileKeys {
+		if profileKey.Type != "string" {
+			continue
+		}
+		replacement, exists := replacements[profileKey.StringVal]
+		if !exists {
+			continue
+		}
+		slog.Debug("Anonymizing profile key", "keyname", keyname)
+		edits = append(edits, stringEdit{profileKey.Offset, profileKey.EndOffset, replacement})
+	}
+	anonymizedData := applyStringEdits(&data, rootNode, edits)
+
+	compressed, err := Compressl33t(&anonymizedData)
+	if err != nil {
+		return nil, err
+	}
+	output, err := replaceL33tSection(&rawData, l33tOffset, l33tEndOffset, compressed)
+	if err != nil {
+		return nil, err
+	}
+
+	// Make sure the parser still reads the result before writing it
+	original, err := decodeReplay(rawData)
+	if err != nil {
+		return nil, err
+	}
+	anonymized, err := decodeReplay(output)
+	if err != nil {
+		return nil, fmt.Errorf("anonymized replay doesn't parse: %w", err)
+	}
+	if len(anonymized.commandList) != len(original.commandList) {
+		return nil, fmt.Errorf(
+			"anonymized replay has %d commands instead of %d",
+			len(anonymized.commandList),
+			len(original.commandList),
+		)
+	}
+	if residualPlayers := playersWithRemainingNames(&anonymizedData, &output, playerAliases); len(residualPlayers) > 0 {
+		if !allowResidual {
+			return nil, fmt.Errorf("%w, players %v", ErrResidualNames, residualPlayers)
+		}
+		slog.Warn("Player names or profile ids are still in the replay outside of the profile keys", "playerNums", residualPlayers)
+	}
+
+	if isGzip {
+		var buffer bytes.Buffer
+		writer := gzip.NewWriter(&buffer)
+		if _, err := writer.Write(output); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		output = buffer.Bytes()
+	}
+	if err := os.WriteFile(outputPath, output, 0644); err != nil {
+		return nil, err
+	}
+	return playerAliases, nil
+}
+
+// encodeString is the inverse of readString
+func encodeString(value string) []byte {
+	u16s := utf16.Encode([]rune(value))
+	encoded := make([]byte, 0, 4+2*len(u16s))
+	encoded = binary.LittleEndian.AppendUint16(encoded, uint16(len(u16s)))
+	encoded = binary.LittleEndian.AppendUint16(encoded, 0)
+	for _, u16 := range u16s {
+		encoded = binary.LittleEndian.AppendUint16(encoded, u16)
+	}
+	return encoded
+}
+
+// applyStringEdits returns a copy of data with the edits applied. Every header node containing an edit has its size
+// grown or shrunk by the change in length. The size fields sit before the edits they cover, so they are written into
+// the copy first and the strings spliced in afterwards.
+func applyStringEdits(data *[]byte, rootNode Node, edits []stringEdit) []byte {
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].offset < edits[j].offset
+	})
+	encodedEdits := make([][]byte, len(edits))
+	for i, edit := range edits {
+		encodedEdits[i] = encodeString(edit.value)
+	}
+
+	edited := append([]byte{}, *data...)
+	var fixSizes func(node *Node)
+	fixSizes = func(node *Node) {
+		size := int(node.size)
+		for i, edit := range edits {
+			if edit.offset >= node.offset+DATA_OFFSET && edit.endOffset <= node.endOffset() {
+				size += len(encodedEdits[i]) - (edit.endOffset - edit.offset)
+			}
+		}
+		if size != int(node.size) {
+			slog.Debug("Fixing node size", "path", node.path(), "size", node.size, "newSize", size)
+			binary.LittleEndian.PutUint32(edited[node.offset+2:node.offset+DATA_OFFSET], uint32(size))
+		}
+		for _, child := range node.children {
+			fixSizes(child)
+		}
+	}
+	fixSizes(&rootNode)
+
+	result := make([]byte, 0, len(edited))
+	position := 0
+	for i, edit := range edits {
+		result = append(result, edited[position:edit.offset]...)
+		result = append(result, encodedEdits[i]...)
+		position = edit.endOffset
+	}
+	return append(result, edited[position:]...)
+}
+
+// replaceL33tSection swaps the l33t section of the outer buffer for compressed and moves the command stream offset
+// (and the "sv" bytes it is stored after) along with everything behind the section
+func replaceL33tSection(rawData *[]byte, l33tOffset int, l33tEndOffset int, compressed []byte) ([]byte, error) {
+	delta := len(compressed) - (l33tEndOffset - l33tOffset)
+	svOffset := bytes.Index(*rawData, []byte{0x73, 0x76})
+	if svOffset == -1 {
+		return nil, fmt.Errorf("no sv bytes in the replay")
+	}
+	if svOffset+6 > l33tOffset && svOffset < l33tEndOffset {
+		return nil, fmt.Errorf("sv bytes at %d are inside the l33t section", svOffset)
+	}
+
+	output := make([]byte, 0, len(*rawData)+delta)
+	output = append(output, (*rawData)[:l33tOffset]...)
+	output = append(output, compressed...)
+	output = append(output, (*rawData)[l33tEndOffset:]...)
+
+	commandOffset := int(readUint32(rawData, svOffset+2))
+	if commandOffset >= l33tEndOffset {
+		newSvOffset := svOffset
+		if svOffset >= l33tEndOffset {
+			newSvOffset += delta
+		}
+		binary.LittleEndian.PutUint32(output[newSvOffset+2:newSvOffset+6], uint32(commandOffset+delta))
+	}
+	return output, nil
+}
+
+// minResidualLength is the shortest name or profile id searched for after anonymizing, shorter ones would match
+// unrelated bytes
+const minResidualLength = 3
+
+// playersWithRemainingNames returns the numbers of the players whose names or profile ids are still in the replay
+// outside of the profile keys, e.g., in header nodes the parser doesn't read
+func playersWithRemainingNames(data *[]byte, rawData *[]byte, playerAliases []PlayerAlias) []int {
+	playerNums := make([]int, 0)
+	for _, playerAlias := range playerAliases {
+		needles := make([][]byte, 0)
+		if playerAlias.Name != playerAlias.Alias {
+			if len(playerAlias.Name) < minResidualLength {
+				slog.Warn("Player name is too short to check for in the rest of the replay", "playerNum", playerAlias.PlayerNum)
+			} else {
+				// The length prefix is left out, the name may be stored as part of a longer string
+				needles = append(needles, encodeString(playerAlias.Name)[4:])
+			}
+		}
+		if playerAlias.ProfileId != "" {
+			if len(playerAlias.ProfileId) < minResidualLength {
+				slog.Warn("Profile id is too short to check for in the rest of the replay", "playerNum", playerAlias.PlayerNum)
+			} else {
+				// Profile ids may also be stored as plain ASCII, e.g., in urls
+				needles = append(needles, encodeString(playerAlias.ProfileId)[4:], []byte(playerAlias.ProfileId))
+			}
+		}
+		for _, needle := range needles {
+			if bytes.Contains(*data, needle) || bytes.Contains(*rawData, needle) {
+				playerNums = append(playerNums, playerAlias.PlayerNum)
+				break
+			}
+		}
+	}
+	return playerNums
+}
+
+func PlayerAliasesToText(playerAliases []PlayerAlias) string {
+	var sb strings.Builder
+	for _, playerAlias := range playerAliases {
+		sb.WriteString(fmt.Sprintf("player %d: %s -> %s", playerAlias.PlayerNum, playerAlias.Name, playerAlias.Alias))
+		if playerAlias.ProfileId != "" {
+			sb.WriteString(fmt.Sprintf(", profile id %s -> %s", playerAlias.ProfileId, playerAlias.AliasProfileId))
+		}
+		sb.WriteString("\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,111 @@
+package parser_test
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+func TestAnonymizeReplay(t *testing.T) {
+	replay := testReplay(
+		encoder.Train{CommandHeader: at(1, 20, 100), ProtoUnitId: villager},
+		encoder.Research{CommandHeader: at(2, 40, 200), TechId: plow},
+		encoder.Resign{CommandHeader: at(2, 60)},
+	)
+	replayPath := writeReplay(t, replay)
+	outputPath := filepath.Join(t.TempDir(), "anonymized.mythrec")
+
+	playerAliases, err := parser.AnonymizeReplay(replayPath, outputPath, map[string]string{"bob": "Coach"}, false, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantAliases := []parser.PlayerAlias{
+		{PlayerNum: 1, Name: "alice", Alias: "Player1", ProfileId: "1001", AliasProfileId: "-1"},
+		{PlayerNum: 2, Name: "bob", Alias: "Coach", ProfileId: "1002", AliasProfileId: "-2"},
+	}
+	if len(playerAliases) != len(wantAliases) {
+		t.Fatalf("aliases = %+v, want %+v", playerAliases, wantAliases)
+	}
+	for i, want := range wantAliases {
+		if playerAliases[i] != want {
+			t.Errorf("alias %d = %+v, want %+v", i, playerAliases[i], want)
+		}
+	}
+
+	// The header has to come out exactly as if the replay had been recorded with the aliases, node sizes and the
+	// command stream offset included
+	anonymized, err := os.ReadFile(outputPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	renamed := replay
+	renamed.Players = []encoder.Player{replay.Players[0], replay.Players[1]}
+	renamed.Players[0].Name, renamed.Players[0].ProfileId = "Player1", "-1"
+	renamed.Players[1].Name, renamed.Players[1].ProfileId = "Coach", "-2"
+	want, err := encoder.Encode(renamed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantHeader, err := parser.Decompressl33t(&want)
+	if err != nil {
+		t.Fatal(err)
+	}
+	anonymizedHeader, err := parser.Decompressl33t(&anonymized)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(anonymizedHeader, wantHeader) {
+		t.Error("anonymized header differs from the header encoded with the aliases")
+	}
+
+	original, err := parser.Parse(replayPath, parser.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	formatted, err := parser.Parse(outputPath, parser.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, player := range formatted.Players {
+		if player.Name != wantAliases[i].Alias || player.ProfileId != -player.PlayerNum {
+			t.Errorf("player %d is %s (%d), want %s (%d)",
+				player.PlayerNum, player.Name, player.ProfileId, wantAliases[i].Alias, -player.PlayerNum)
+		}
+	}
+	if len(*formatted.GameCommands) != len(*original.GameCommands) {
+		t.Errorf("anonymized replay has %d commands, want %d", len(*formatted.GameCommands), len(*original.GameCommands))
+	}
+}
+
+func TestAnonymizeReplayResidue(t *testing.T) {
+	tests := []struct {
+		name       string
+		profileKey encoder.ProfileKey
+	}{
+		{"name in a longer string", encoder.ProfileKey{Name: "gamedescription", Value: "alice hosts"}},
+		{"profile id in a longer string", encoder.ProfileKey{Name: "gamehosturl", Value: "https://example.com/1002"}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replay := testReplay(encoder.Resign{CommandHeader: at(2, 20)})
+			replay.ProfileKeys = []encoder.ProfileKey{test.profileKey}
+			replayPath := writeReplay(t, replay)
+			outputPath := filepath.Join(t.TempDir(), "anonymized.mythrec")
+
+			if _, err := parser.AnonymizeReplay(replayPath, outputPath, nil, false, false); !errors.Is(err, parser.ErrResidualNames) {
+				t.Fatalf("err = %v, want ErrResidualNames", err)
+			}
+			if _, err := os.Stat(outputPath); !os.IsNotExist(err) {
+				t.Error("replay with residual names was written")
+			}
+			if _, err := parser.AnonymizeReplay(replayPath, outputPath, nil, true, false); err != nil {
+				t.Fatalf("allowResidual: %v", err)
+			}
+		})
+	}
+}
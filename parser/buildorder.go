@@ -0,0 +1,203 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strings"
+)
+
+// A build order is a condensed view of the economic and tech decisions a player made in the opening of a game.
+// Consecutive clicks of the same thing (e.g., five villager trains in a row) are folded into a single step so that the
+// output reads like the build orders players share with each other.
+
+var buildOrderActions = map[string]struct{}{
+	"train":        {},
+	"build":        {},
+	"research":     {},
+	"prequeueTech": {},
+	"godPower":     {},
+}
+
+func BuildOrderToJson(replayPath string, untilSecs float64, prettyPrint bool, isGzip bool) (string, error) {
+	buildOrder, err := ParseBuildOrder(replayPath, untilSecs, isGzip)
+	if err != nil {
+		return "", err
+	}
+
+	var jsonBytes []byte
+	if prettyPrint {
+		jsonBytes, err = json.MarshalIndent(buildOrder, "", "    ")
+	} else {
+		jsonBytes, err = json.Marshal(buildOrder)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+func BuildOrderToText(replayPath string, untilSecs float64, isGzip bool) (string, error) {
+	buildOrder, err := ParseBuildOrder(replayPath, untilSecs, isGzip)
+	if err != nil {
+		return "", err
+	}
+	return formatBuildOrderText(buildOrder), nil
+}
+
+// ParseBuildOrder extracts the build order of every player up to untilSecs of game time. An untilSecs of 0 or less
+// means the whole game.
+func ParseBuildOrder(replayPath string, untilSecs float64, isGzip bool) (ReplayBuildOrder, error) {
+	replay, err := readReplay(replayPath, isGzip)
+	if err != nil {
+		return ReplayBuildOrder{}, err
+	}
+	formatterInput, err := buildFormatterInput(&replay.data, &replay.xmbMap)
+	if err != nil {
+		return ReplayBuildOrder{}, err
+	}
+	replay.commandList = resolveCancelledItems(replay.commandList, formatterInput)
+
+	formattedReplay, err := formatRawDataToReplay(
+		ParseOptions{Slim: true, IsGzip: isGzip},
+		&replay.data,
+		&replay.rootNode,
+		&replay.profileKeys,
+		&replay.xmbMap,
+		&replay.commandList,
+		&replay.selections,
+		&formatterInput,
+	)
+	if err != nil {
+		return ReplayBuildOrder{}, err
+	}
+
+	if untilSecs <= 0 {
+		untilSecs = formattedReplay.GameLengthSecs
+	}
+
+	return ReplayBuildOrder{
+		MapName:   formattedReplay.MapName,
+		UntilSecs: untilSecs,
+		Players:   getBuildOrders(&formattedReplay.Players, &replay.commandList, formatterInput, untilSecs),
+	}, nil
+}
+
+func getBuildOrders(
+	players *[]ReplayPlayer,
+	commandList *[]RawGameCommand,
+	formatterInput FormatterInput,
+	untilSecs float64,
+) []PlayerBuildOrder {
+	stepsByPlayer := make(map[int][]BuildOrderStep)
+	for _, command := range *commandList {
+		if command.GameTimeSecs() > untilSecs {
+			break
+		}
+
+		formattedCommand, ok := command.Format(formatterInput)
+		if !ok {
+			continue
+		}
+		if _, exists := buildOrderActions[formattedCommand.CommandType]; !exists {
+			continue
+		}
+
+		step := newBuildOrderStep(command, formattedCommand)
+		steps := stepsByPlayer[command.PlayerId()]
+		if len(steps) > 0 && canMergeBuildOrderSteps(steps[len(steps)-1], step) {
+			steps[len(steps)-1].Count += step.Count
+		} else {
+			steps = append(steps, step)
+		}
+		stepsByPlayer[command.PlayerId()] = steps
+	}
+
+	buildOrders := make([]PlayerBuildOrder, 0)
+	for _, player := range *players {
+		ageUps := make([]AgeUpItem, 0)
+		for _, ageUp := range player.AgeUps {
+			if ageUp.ClickedAtSecs <= untilSecs {
+				ageUps = append(ageUps, ageUp)
+			}
+		}
+		steps := stepsByPlayer[player.PlayerNum]
+		if steps == nil {
+			steps = make([]BuildOrderStep, 0)
+		}
+		buildOrders = append(buildOrders, PlayerBuildOrder{
+			PlayerNum: player.PlayerNum,
+			Name:      player.Name,
+			God:       player.God,
+			AgeUps:    ageUps,
+			Steps:     steps,
+		})
+	}
+	return buildOrders
+}
+
+// newBuildOrderStep counts every unit of a train batch and a single item for any other click
+func newBuildOrderStep(command RawGameCommand, formattedCommand ReplayGameCommand) BuildOrderStep {
+	step := BuildOrderStep{
+		GameTimeSecs: formattedCommand.GameTimeSecs,
+		Action:       formattedCommand.CommandType,
+		Count:        1,
+	}
+	if train, ok := command.(TrainCommand); ok {
+		step.Count = train.batchSize()
+	}
+
+	switch payload := formattedCommand.Payload.(type) {
+	case string:
+		step.Name = payload
+	case BuildCommandPaylod:
+		step.Name = payload.Name
+	case ProtoPowerPayload:
+		step.Name = payload.Name
+	}
+
+	if (step.Action == "research" || step.Action == "prequeueTech") && ageOfTech(step.Name) != "" {
+		step.Action = "ageUp"
+	}
+	return step
+}
+
+func canMergeBuildOrderSteps(previous BuildOrderStep, next BuildOrderStep) bool {
+	if previous.Action != next.Action || previous.Name != next.Name {
+		return false
+	}
+	return next.Action == "train" || next.Action == "build"
+}
+
+func formatBuildOrderText(buildOrder ReplayBuildOrder) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf("Map: %s, until %s\n", buildOrder.MapName, formatGameTime(buildOrder.UntilSecs)))
+	for _, player := range buildOrder.Players {
+		sb.WriteString(fmt.Sprintf("\nPlayer %d: %s (%s)\n", player.PlayerNum, player.Name, player.God))
+		for _, ageUp := range player.AgeUps {
+			sb.WriteString(fmt.Sprintf(
+				"  %s Age: clicked %s, done ~%s (%s)\n",
+				ageUp.Age,
+				formatGameTime(ageUp.ClickedAtSecs),
+				formatGameTime(ageUp.CompletedAtSecs),
+				ageUp.Name,
+			))
+		}
+		for _, step := range player.Steps {
+			line := fmt.Sprintf("  %s  %-12s %s", formatGameTime(step.GameTimeSecs), step.Action, step.Name)
+			if step.Count > 1 {
+				line += fmt.Sprintf(" x%d", step.Count)
+			}
+			sb.WriteString(line + "\n")
+		}
+	}
+	return sb.String()
+}
+
+// formatGameTime formats seconds of game time as mm:ss
+func formatGameTime(secs float64) string {
+	total := int(math.Floor(secs))
+	return fmt.Sprintf("%02d:%02d", total/60, total%60)
+}
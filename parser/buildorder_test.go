@@ -0,0 +1,43 @@
+package parser_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+func TestBuildOrderCountsTrainBatches(t *testing.T) {
+	replayPath := writeReplay(t, testReplay(
+		encoder.Train{CommandHeader: at(1, 20, 100), ProtoUnitId: 0, NumUnits: 1},
+		encoder.Train{CommandHeader: at(1, 40, 100), ProtoUnitId: 0, NumUnits: 5},
+		encoder.Build{CommandHeader: at(1, 60, 7), ProtoBuildingId: 2},
+		encoder.Train{CommandHeader: at(1, 80, 100), ProtoUnitId: 0},
+		encoder.Research{CommandHeader: at(1, 100, 100), TechId: 0},
+	))
+
+	buildOrder, err := parser.ParseBuildOrder(replayPath, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type step struct {
+		Action string
+		Name   string
+		Count  int
+	}
+	var steps []step
+	for _, buildOrderStep := range buildOrder.Players[0].Steps {
+		steps = append(steps, step{buildOrderStep.Action, buildOrderStep.Name, buildOrderStep.Count})
+	}
+	want := []step{
+		{"train", "Villager", 6},
+		{"build", "House", 1},
+		// A train without a batch size is a single unit
+		{"train", "Villager", 1},
+		{"ageUp", "ClassicalAge", 1},
+	}
+	if !reflect.DeepEqual(steps, want) {
+		t.Errorf("steps = %+v, want %+v", steps, want)
+	}
+}
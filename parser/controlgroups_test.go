@@ -0,0 +1,56 @@
+package parser_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// Operation ids of the changeControlGroupContents command
+const (
+	removeFromGroup = 0
+	addToGroup      = 1
+)
+
+func TestControlGroups(t *testing.T) {
+	group := func(tick int, group int32, unitId int32, operation int8) encoder.Command {
+		return encoder.ChangeControlGroupContents{CommandHeader: at(1, tick), Group: group, UnitId: unitId, Operation: operation}
+	}
+	replay := testReplay(
+		group(20, 1, 100, addToGroup),
+		group(20, 1, 101, addToGroup),
+		// Setting a group removes its units and adds the new ones in the same tick
+		group(40, 1, 100, removeFromGroup),
+		group(40, 1, 101, removeFromGroup),
+		group(40, 1, 102, addToGroup),
+		group(60, 2, 103, addToGroup),
+		group(80, 2, 103, removeFromGroup),
+		encoder.Resign{CommandHeader: at(2, 100)},
+	)
+
+	formatted, err := parser.Parse(writeReplay(t, replay), parser.ParseOptions{Stats: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := (*formatted.Stats)[1]
+
+	wantItems := []parser.ControlGroupItem{
+		{GameTimeSecs: 1, Group: 1, Operation: "add", NumUnits: 2},
+		{GameTimeSecs: 2, Group: 1, Operation: "set", NumUnits: 1},
+		{GameTimeSecs: 3, Group: 2, Operation: "add", NumUnits: 1},
+		{GameTimeSecs: 4, Group: 2, Operation: "remove", NumUnits: 1},
+	}
+	if !reflect.DeepEqual(stats.Timelines.ControlGroups, wantItems) {
+		t.Errorf("control group timeline = %+v, want %+v", stats.Timelines.ControlGroups, wantItems)
+	}
+	wantStats := parser.ControlGroupStats{
+		GroupsUsed:       []int{1, 2},
+		AssignmentCounts: map[int]int{1: 2, 2: 1},
+		Reassignments:    1,
+	}
+	if !reflect.DeepEqual(stats.ControlGroups, wantStats) {
+		t.Errorf("control group stats = %+v, want %+v", stats.ControlGroups, wantStats)
+	}
+}
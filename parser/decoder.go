@@ -5,7 +5,6 @@ import (
 	"compress/gzip"
 	"compress/zlib"
 	"encoding/binary"
-	"fmt"
 	"io"
 	"log/slog"
 	"math"
@@ -78,21 +77,50 @@ func Decompressl33t(compressed_array *[]byte) ([]byte, error) {
 		Decompresses a l33t compressed byte stream. The header must l33t, then the following bytes are decompressed
 		using the zlib compression.
 	*/
+	data, _, _, err := decompressl33tSection(compressed_array)
+	return data, err
+}
+
+// decompressl33tSection decompresses like Decompressl33t and also returns where the l33t section starts and ends in
+// compressed_array, from the l33t header to the end of the zlib stream.
+func decompressl33tSection(compressed_array *[]byte) ([]byte, int, int, error) {
 	offset := bytes.Index(*compressed_array, []byte{0x6c, 0x33, 0x33, 0x74}) // Find the l33t header
-	header := string((*compressed_array)[offset : offset+4])
-	if header != "l33t" {
-		return nil, NotL33t(fmt.Sprintf("Data is no l33t compressed, incorrect header: \"%s\"", header))
+	if offset == -1 {
+		return nil, -1, -1, NotL33t("Data is no l33t compressed, no l33t header found")
 	}
+	header := string((*compressed_array)[offset : offset+4])
 	slog.Debug("compressed_size", "compressed_size", strconv.FormatInt(int64(len(*compressed_array)), 16))
 	slog.Debug("Decompressing l33t compressed data", "header", header)
 
-	reader, err := zlib.NewReader(bytes.NewReader((*compressed_array)[offset+8:]))
+	compressedReader := bytes.NewReader((*compressed_array)[offset+8:])
+	reader, err := zlib.NewReader(compressedReader)
+	if err != nil {
+		return nil, -1, -1, err
+	}
 	defer reader.Close()
+
+	data, err := io.ReadAll(reader)
 	if err != nil {
-		return nil, err
+		return nil, -1, -1, err
 	}
+	// bytes.Reader is an io.ByteReader, so zlib reads it one byte at a time and never past the end of the stream
+	endOffset := len(*compressed_array) - compressedReader.Len()
+	return data, offset, endOffset, nil
+}
 
-	return io.ReadAll(reader)
+// Compressl33t is the inverse of Decompressl33t: the l33t header, the uint32 size of data and data zlib compressed
+func Compressl33t(data *[]byte) ([]byte, error) {
+	var buffer bytes.Buffer
+	buffer.WriteString("l33t")
+	buffer.Write(binary.LittleEndian.AppendUint32(nil, uint32(len(*data))))
+	writer := zlib.NewWriter(&buffer)
+	if _, err := writer.Write(*data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
 }
 
 func DecompressGzip(compressed_array *[]byte) ([]byte, error) {
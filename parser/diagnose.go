@@ -0,0 +1,242 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// When a patch changes the body length of a command type, every command after the first instance of that type is read
+// from the wrong offset and the parse fails a little later, usually at an `expecting three` or `final byte doesn't
+// equal 0` check. DiagnoseLayouts automates the A/B testing of alternate lengths that tools/trace_command_stream.py
+// does by hand with --prequeue-tech-bytes: it collects the command types parsed right before the failure and, one type
+// at a time, reparses the stream with every body length within a radius of the current one. A length under which the
+// rest of the stream parses (sequential entry indices, valid footers) is very likely the new layout.
+
+// streamWalk is how far a walk over the command stream got, see walkCommandStream
+type streamWalk struct {
+	listsParsed int
+	// failedOffset is where the failing command list starts, or the failing command when failedCommand is set
+	failedOffset  int
+	failedCommand bool
+	// recentCommandTypes holds the command types of the last command lists, the failing list's partial one last
+	recentCommandTypes [][]int
+	err                error
+}
+
+// walkCommandStream parses command lists the way parseGameCommands does, keeping only what the diagnosis needs. A
+// misaligned walk can read past the end of the data, which is reported as a failure rather than a panic.
+func walkCommandStream(
+	data *[]byte,
+	offset int,
+	commandCount int,
+	lengthOverrides map[int]int,
+	window int,
+) (walk streamWalk) {
+	walk.failedOffset = offset
+	defer func() {
+		if r := recover(); r != nil {
+			walk.err = fmt.Errorf("read past the end of the command stream: %v", r)
+		}
+	}()
+
+	for i := 1; i <= commandCount; i++ {
+		walk.failedOffset = offset
+		item, err := parseCommandList(data, offset, i, lengthOverrides, nil)
+
+		commandTypes := make([]int, 0, len(item.commands))
+		for _, command := range item.commands {
+			commandTypes = append(commandTypes, command.CommandType())
+		}
+		walk.recentCommandTypes = append(walk.recentCommandTypes, commandTypes)
+		if len(walk.recentCommandTypes) > window+1 {
+			walk.recentCommandTypes = walk.recentCommandTypes[1:]
+		}
+
+		if err != nil {
+			// parseCommandList only sets offsetEnd on failure when a command failed to parse
+			if item.offsetEnd != 0 {
+				walk.failedOffset = item.offsetEnd
+				walk.failedCommand = true
+			}
+			walk.err = err
+			return walk
+		}
+		if item.entryIdx != i {
+			walk.err = fmt.Errorf("entryIdx was not sequential, item.entryIdx=%v, lastIndex=%v", item.entryIdx, i)
+			return walk
+		}
+		walk.listsParsed++
+		offset = item.offsetEnd
+	}
+	return walk
+}
+
+// suspectCommandTypes returns the command types to try alternate lengths for, closest to the failure first: the type
+// of the command that failed to parse, then the commands of the failing list and the window lists before it, latest
+// first. Only types with a layout are returned, an unregistered type can't have its length overridden.
+func suspectCommandTypes(data *[]byte, walk streamWalk) []int {
+	candidates := make([]int, 0)
+	if walk.failedCommand && walk.failedOffset+1 < len(*data) {
+		candidates = append(candidates, int((*data)[walk.failedOffset+1]))
+	}
+	for i := len(walk.recentCommandTypes) - 1; i >= 0; i-- {
+		commandTypes := walk.recentCommandTypes[i]
+		for j := len(commandTypes) - 1; j >= 0; j-- {
+			candidates = append(candidates, commandTypes[j])
+		}
+	}
+
+	suspects := make([]int, 0)
+	seen := make(map[int]bool)
+	for _, commandType := range candidates {
+		if seen[commandType] {
+			continue
+		}
+		seen[commandType] = true
+		if _, exists := currentLayouts[layoutKey{commandType, ""}]; exists {
+			suspects = append(suspects, commandType)
+		}
+	}
+	return suspects
+}
+
+// DiagnoseLayouts looks for the body length change that breaks parsing a replay, see the top of this file. window is
+// the number of command lists before the failing one whose command types are suspects, radius how far from the current
+// length alternate lengths are searched.
+func DiagnoseLayouts(replayPath string, window int, radius int, isGzip bool) (LayoutDiagnosis, error) {
+	rawData, err := readRawData(replayPath, isGzip)
+	if err != nil {
+		return LayoutDiagnosis{}, err
+	}
+	commandOffset, commandCount := locateCommandStream(&rawData)
+	offset, err := firstCommandListOffset(&rawData, commandOffset)
+	if err != nil {
+		return LayoutDiagnosis{}, err
+	}
+
+	baseline := walkCommandStream(&rawData, offset, commandCount, nil, window)
+	diagnosis := LayoutDiagnosis{
+		CommandCount:   commandCount,
+		ListsParsed:    baseline.listsParsed,
+		FailedAtOffset: -1,
+		SuspectTypes:   make([]int, 0),
+		Candidates:     make([]LayoutCandidate, 0),
+	}
+	if baseline.err == nil {
+		slog.Debug("Command stream parses cleanly, nothing to diagnose", "commandCount", commandCount)
+		return diagnosis, nil
+	}
+	diagnosis.FailedAtOffset = baseline.failedOffset
+	diagnosis.Error = baseline.err.Error()
+	diagnosis.SuspectTypes = suspectCommandTypes(&rawData, baseline)
+
+	for _, commandType := range diagnosis.SuspectTypes {
+		currentLength := currentLayout(commandType).ByteLength
+		for byteLength := currentLength - radius; byteLength <= currentLength+radius; byteLength++ {
+			if byteLength < 0 || byteLength == currentLength {
+				continue
+			}
+			walk := walkCommandStream(&rawData, offset, commandCount, map[int]int{commandType: byteLength}, window)
+			slog.Debug(
+				"Tried alternate body length",
+				"commandType", commandType,
+				"byteLength", byteLength,
+				"listsParsed", walk.listsParsed,
+			)
+			// Lengths that don't get further than the current one tell us nothing
+			if walk.listsParsed <= baseline.listsParsed {
+				continue
+			}
+			candidate := LayoutCandidate{
+				CommandType:   commandType,
+				Name:          commandName(commandType),
+				CurrentLength: currentLength,
+				ByteLength:    byteLength,
+				ListsParsed:   walk.listsParsed,
+				Clean:         walk.err == nil,
+			}
+			if walk.err != nil {
+				candidate.Error = walk.err.Error()
+			}
+			diagnosis.Candidates = append(diagnosis.Candidates, candidate)
+		}
+	}
+
+	sort.SliceStable(diagnosis.Candidates, func(i, j int) bool {
+		a, b := diagnosis.Candidates[i], diagnosis.Candidates[j]
+		if a.ListsParsed != b.ListsParsed {
+			return a.ListsParsed > b.ListsParsed
+		}
+		return absInt(a.ByteLength-a.CurrentLength) < absInt(b.ByteLength-b.CurrentLength)
+	})
+	return diagnosis, nil
+}
+
+func absInt(value int) int {
+	if value < 0 {
+		return -value
+	}
+	return value
+}
+
+func LayoutDiagnosisToJson(diagnosis LayoutDiagnosis, prettyPrint bool) (string, error) {
+	var jsonBytes []byte
+	var err error
+	if prettyPrint {
+		jsonBytes, err = json.MarshalIndent(diagnosis, "", "    ")
+	} else {
+		jsonBytes, err = json.Marshal(diagnosis)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+func LayoutDiagnosisToText(diagnosis LayoutDiagnosis) string {
+	var sb strings.Builder
+	if diagnosis.Error == "" {
+		sb.WriteString(fmt.Sprintf("all %d command lists parse, nothing to diagnose\n", diagnosis.CommandCount))
+		return sb.String()
+	}
+
+	sb.WriteString(fmt.Sprintf(
+		"parse failed after %d of %d command lists at offset %08x: %s\n",
+		diagnosis.ListsParsed,
+		diagnosis.CommandCount,
+		diagnosis.FailedAtOffset,
+		diagnosis.Error,
+	))
+	suspects := make([]string, 0, len(diagnosis.SuspectTypes))
+	for _, commandType := range diagnosis.SuspectTypes {
+		suspects = append(suspects, fmt.Sprintf("%d (%s)", commandType, commandName(commandType)))
+	}
+	sb.WriteString(fmt.Sprintf("suspect command types: %s\n", strings.Join(suspects, ", ")))
+
+	if len(diagnosis.Candidates) == 0 {
+		sb.WriteString("no alternate body length gets further, the change is likely outside the command bodies\n")
+		return sb.String()
+	}
+	sb.WriteString("\ncandidate body lengths:\n")
+	for _, candidate := range diagnosis.Candidates {
+		result := "parses cleanly"
+		if !candidate.Clean {
+			result = fmt.Sprintf("fails at command list %d: %s", candidate.ListsParsed+1, candidate.Error)
+		}
+		sb.WriteString(fmt.Sprintf(
+			"  %d %s: %d bytes instead of %d, %d command lists, %s\n",
+			candidate.CommandType,
+			candidate.Name,
+			candidate.ByteLength,
+			candidate.CurrentLength,
+			candidate.ListsParsed,
+			result,
+		))
+	}
+	return sb.String()
+}
@@ -0,0 +1,60 @@
+package parser_test
+
+import (
+	"os"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+func TestDiagnoseLayouts(t *testing.T) {
+	replayPath := writeReplay(t, testReplay(
+		encoder.Train{CommandHeader: at(1, 10, 100), ProtoUnitId: villager},
+		encoder.PrequeueTech{CommandHeader: at(1, 20, 100), TechId: plow},
+		encoder.Train{CommandHeader: at(1, 40, 100), ProtoUnitId: villager},
+		encoder.Research{CommandHeader: at(2, 60, 200), TechId: plow},
+		encoder.Resign{CommandHeader: at(2, 80)},
+	))
+	// Cut the prequeueTech body down to the 13 bytes builds before 601511 wrote
+	formatted, err := parser.Parse(replayPath, parser.ParseOptions{RawCommands: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	prequeueTech := (*formatted.RawCommands)[1]
+	if prequeueTech.Name != "prequeueTech" {
+		t.Fatalf("second command is %s, want the prequeueTech", prequeueTech.Name)
+	}
+	data, err := os.ReadFile(replayPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodyEnd := prequeueTech.Offset + prequeueTech.ByteLength
+	data = append(data[:bodyEnd-3], data[bodyEnd:]...)
+	if err := os.WriteFile(replayPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diagnosis, err := parser.DiagnoseLayouts(replayPath, 2, 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diagnosis.FailedAtOffset < 0 {
+		t.Fatal("the replay parsed with the current layouts")
+	}
+	if len(diagnosis.Candidates) == 0 {
+		t.Fatalf("no candidates, suspects %v, error %s", diagnosis.SuspectTypes, diagnosis.Error)
+	}
+	best := diagnosis.Candidates[0]
+	if best.CommandType != 72 || best.ByteLength != 13 || !best.Clean || best.ListsParsed != diagnosis.CommandCount {
+		t.Errorf("best candidate = %+v, want a clean prequeueTech with 13 bytes", best)
+	}
+
+	clean, err := parser.DiagnoseLayouts(writeReplay(t, testReplay(encoder.Resign{CommandHeader: at(2, 20)})), 2, 4, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clean.FailedAtOffset != -1 || len(clean.Candidates) != 0 {
+		t.Errorf("diagnosis of a replay that parses = %+v, want nothing to diagnose", clean)
+	}
+}
@@ -0,0 +1,71 @@
+package parser
+
+import (
+	"log/slog"
+)
+
+// Diplomacy starts out from the lobby teams, players on the same team are allies and everyone else is an enemy. A free
+// for all lobby has no teams, so there everyone starts out as an enemy, as do players without a team (noTeam) and
+// players in a lobby where everyone shares a single team id. diplomacy commands then change a player's stance towards
+// another player, which happens in FFA and custom scenario games where alliances shift mid-game. Stances are one
+// directional, player 1 can be allied to player 2 while player 2 is an enemy of player 1.
+
+// noTeam is the team id of a player that isn't on a team, team ids below it are treated the same
+const noTeam = -1
+
+func getDiplomacy(gameCommands *[]ReplayGameCommand, players *[]ReplayPlayer, freeForAll bool) ReplayDiplomacy {
+	teamIds := make(map[int]struct{})
+	for _, player := range *players {
+		teamIds[player.TeamId] = struct{}{}
+	}
+	hasTeams := !freeForAll && len(teamIds) > 1
+
+	stances := make(map[int]map[int]string)
+	for _, player := range *players {
+		stances[player.PlayerNum] = make(map[int]string)
+		for _, other := range *players {
+			if player.PlayerNum == other.PlayerNum {
+				continue
+			}
+			if startsAllied(player, other, hasTeams) {
+				stances[player.PlayerNum][other.PlayerNum] = string(AllyStance)
+			} else {
+				stances[player.PlayerNum][other.PlayerNum] = string(EnemyStance)
+			}
+		}
+	}
+
+	changes := make([]DiplomacyChange, 0)
+	for _, command := range *gameCommands {
+		if command.CommandType != "diplomacy" {
+			continue
+		}
+		payload := command.Payload.(DiplomacyPayload)
+		playerStances, exists := stances[command.PlayerNum]
+		if !exists {
+			slog.Debug("Diplomacy change by unknown player", "playerNum", command.PlayerNum)
+			continue
+		}
+		previous := playerStances[payload.TargetPlayer]
+		playerStances[payload.TargetPlayer] = payload.Stance
+		changes = append(changes, DiplomacyChange{
+			GameTimeSecs:   command.GameTimeSecs,
+			PlayerNum:      command.PlayerNum,
+			TargetPlayer:   payload.TargetPlayer,
+			PreviousStance: previous,
+			Stance:         payload.Stance,
+		})
+	}
+
+	return ReplayDiplomacy{
+		FinalStances: stances,
+		Changes:      changes,
+	}
+}
+
+func startsAllied(player ReplayPlayer, other ReplayPlayer, hasTeams bool) bool {
+	if !hasTeams || player.TeamId <= noTeam {
+		return false
+	}
+	return player.TeamId == other.TeamId
+}
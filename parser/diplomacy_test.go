@@ -0,0 +1,76 @@
+package parser_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// Stance ids of the changeDiplomacy command
+const (
+	allyStance  = 0
+	enemyStance = 2
+)
+
+func TestDiplomacy(t *testing.T) {
+	tests := []struct {
+		name       string
+		freeForAll bool
+		commands   []encoder.Command
+		stances    map[int]map[int]string
+		changes    []parser.DiplomacyChange
+	}{
+		{
+			name: "teams start allied and stances change one way",
+			commands: []encoder.Command{
+				encoder.ChangeDiplomacy{CommandHeader: at(1, 40), Stance: allyStance, TargetPlayer: 2},
+				encoder.ChangeDiplomacy{CommandHeader: at(3, 60), Stance: enemyStance, TargetPlayer: 1},
+			},
+			stances: map[int]map[int]string{
+				1: {2: "ally", 3: "ally"},
+				2: {1: "enemy", 3: "enemy"},
+				3: {1: "enemy", 2: "enemy"},
+			},
+			changes: []parser.DiplomacyChange{
+				{GameTimeSecs: 2, PlayerNum: 1, TargetPlayer: 2, PreviousStance: "enemy", Stance: "ally"},
+				{GameTimeSecs: 3, PlayerNum: 3, TargetPlayer: 1, PreviousStance: "ally", Stance: "enemy"},
+			},
+		},
+		{
+			name:       "everyone starts as an enemy in a free for all",
+			freeForAll: true,
+			commands: []encoder.Command{
+				encoder.ChangeDiplomacy{CommandHeader: at(2, 40), Stance: allyStance, TargetPlayer: 3},
+			},
+			stances: map[int]map[int]string{
+				1: {2: "enemy", 3: "enemy"},
+				2: {1: "enemy", 3: "ally"},
+				3: {1: "enemy", 2: "enemy"},
+			},
+			changes: []parser.DiplomacyChange{
+				{GameTimeSecs: 2, PlayerNum: 2, TargetPlayer: 3, PreviousStance: "enemy", Stance: "ally"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replay := testReplay(append(test.commands, encoder.Resign{CommandHeader: at(2, 100)})...)
+			// carol is on alice's team
+			replay.Players = append(replay.Players, encoder.Player{Num: 3, Name: "carol", ProfileId: "1003", Team: 1, Civ: 1})
+			replay.ProfileKeys = []encoder.ProfileKey{{Name: "gamefreeforall", Value: test.freeForAll}}
+
+			formatted, err := parser.Parse(writeReplay(t, replay), parser.ParseOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(formatted.Diplomacy.FinalStances, test.stances) {
+				t.Errorf("final stances = %v, want %v", formatted.Diplomacy.FinalStances, test.stances)
+			}
+			if !reflect.DeepEqual(formatted.Diplomacy.Changes, test.changes) {
+				t.Errorf("changes = %+v, want %+v", formatted.Diplomacy.Changes, test.changes)
+			}
+		})
+	}
+}
@@ -0,0 +1,114 @@
+package parser
+
+import (
+	"math"
+	"strings"
+)
+
+// The eco layout relates where a player put their gather points to where they placed their dropsites. A gather point
+// far away from the nearest dropsite means villagers walk a long way with every load, which is one of the most common
+// mistakes in eco layout. The starting Town Center isn't placed with a build command, so its location is unknown and
+// gather points around it only have a dropsite once the player built one nearby.
+
+// dropsiteUnitTypePrefix starts the unit types (e.g., DropsiteFood) the proto XMB gives the buildings villagers drop
+// their resources off at
+const dropsiteUnitTypePrefix = "Dropsite"
+
+// defaultDropsites are the dropsites of replays without a proto XMB to read them from
+var defaultDropsites = map[string]struct{}{
+	"TownCenter":    {},
+	"Granary":       {},
+	"Storehouse":    {},
+	"Dock":          {},
+	"EconomicGuild": {},
+}
+
+// dropsitesOf returns the names of the protos the proto XMB flags as dropsites. It falls back to defaultDropsites when
+// there is no proto XMB or none of its protos is flagged.
+func dropsitesOf(protoRootNode *XmbNode) map[string]struct{} {
+	if protoRootNode == nil {
+		return defaultDropsites
+	}
+	dropsites := make(map[string]struct{})
+	for _, proto := range protoRootNode.children {
+		for _, child := range proto.children {
+			if child.elementName == "unittype" && strings.HasPrefix(child.value, dropsiteUnitTypePrefix) {
+				dropsites[proto.attributes["name"]] = struct{}{}
+				break
+			}
+		}
+	}
+	if len(dropsites) == 0 {
+		return defaultDropsites
+	}
+	return dropsites
+}
+
+// groundDistance is the distance between two points on the ground, the Y axis is height so it is left out
+func groundDistance(a Vector3, b Vector3) float64 {
+	dx := float64(b.X) - float64(a.X)
+	dz := float64(b.Z) - float64(a.Z)
+	return math.Sqrt(dx*dx + dz*dz)
+}
+
+func calcEcoLayout(playerCommandList *[]ReplayGameCommand, dropsites map[string]struct{}) EcoLayout {
+	layout := EcoLayout{
+		Buildings:    make([]EcoBuildingPlacement, 0),
+		GatherPoints: make([]GatherPointPlacement, 0),
+	}
+
+	for _, command := range *playerCommandList {
+		switch command.CommandType {
+		case "build":
+			payload := command.Payload.(BuildCommandPaylod)
+			if _, exists := dropsites[payload.Name]; !exists {
+				continue
+			}
+			layout.Buildings = append(layout.Buildings, EcoBuildingPlacement{
+				Name:                       payload.Name,
+				GameTimeSecs:               command.GameTimeSecs,
+				Location:                   payload.Location,
+				NearestGatherPointDistance: -1,
+			})
+		case "setGatherPoint":
+			payload := command.Payload.(SetGatherPointPayload)
+			gatherPoint := GatherPointPlacement{
+				GameTimeSecs:     command.GameTimeSecs,
+				Location:         payload.Location,
+				DropsiteDistance: -1,
+			}
+			// Only dropsites placed before the gather point was set are candidates
+			for _, building := range layout.Buildings {
+				distance := groundDistance(building.Location, payload.Location)
+				if gatherPoint.DropsiteDistance < 0 || distance < gatherPoint.DropsiteDistance {
+					gatherPoint.NearestDropsite = building.Name
+					gatherPoint.DropsiteDistance = distance
+				}
+			}
+			layout.GatherPoints = append(layout.GatherPoints, gatherPoint)
+		}
+	}
+
+	for i := range layout.Buildings {
+		building := &layout.Buildings[i]
+		for _, gatherPoint := range layout.GatherPoints {
+			distance := groundDistance(building.Location, gatherPoint.Location)
+			if building.NearestGatherPointDistance < 0 || distance < building.NearestGatherPointDistance {
+				building.NearestGatherPointDistance = distance
+			}
+		}
+	}
+
+	totalDistance := 0.0
+	numWithDropsite := 0
+	for _, gatherPoint := range layout.GatherPoints {
+		if gatherPoint.DropsiteDistance >= 0 {
+			totalDistance += gatherPoint.DropsiteDistance
+			numWithDropsite++
+		}
+	}
+	if numWithDropsite > 0 {
+		layout.AverageDropsiteDistance = totalDistance / float64(numWithDropsite)
+	}
+	return layout
+}
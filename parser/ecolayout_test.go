@@ -0,0 +1,85 @@
+package parser_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// Proto ids of the catalog added by withDropsites
+const (
+	granary    = 4
+	storehouse = 5
+)
+
+// withDropsites extends the proto XMB of testReplay with a Granary and a Storehouse and gives the protos in flags the
+// unittype children the game flags dropsites with
+func withDropsites(replay encoder.Replay, flags map[int][]string) encoder.Replay {
+	protos := withPoints(
+		encoder.ProtoXmb("Villager", "Hoplite", "House", "TownCenter", "Granary", "Storehouse"),
+		"trainpoints",
+		15,
+		20,
+	)
+	for protoId, unitTypes := range flags {
+		for _, unitType := range unitTypes {
+			protos.Children[protoId].Children = append(
+				protos.Children[protoId].Children,
+				encoder.XmbElement{Name: "unittype", Value: unitType},
+			)
+		}
+	}
+	replay.Xmbs[1] = protos
+	return replay
+}
+
+func TestEcoLayoutDropsites(t *testing.T) {
+	commands := []encoder.Command{
+		encoder.Build{CommandHeader: at(1, 20, 100), ProtoBuildingId: granary, Location: parser.Vector3{X: 100, Z: 100}},
+		encoder.Build{CommandHeader: at(1, 40, 100), ProtoBuildingId: storehouse, Location: parser.Vector3{X: 200, Z: 100}},
+		encoder.SetGatherPoint{CommandHeader: at(1, 60, 101), TargetUnitId: -1, Location: parser.Vector3{X: 110, Z: 100}},
+		encoder.Resign{CommandHeader: at(2, 80)},
+	}
+	tests := []struct {
+		name string
+		// flags are the unittype children of the protos in the proto XMB
+		flags           map[int][]string
+		dropsites       []string
+		nearestDropsite string
+	}{
+		{
+			name:            "dropsites are read from the proto XMB",
+			flags:           map[int][]string{storehouse: {"Building", "DropsiteWood", "DropsiteGold"}},
+			dropsites:       []string{"Storehouse"},
+			nearestDropsite: "Storehouse",
+		},
+		{
+			name:            "without flags the default dropsites are used",
+			dropsites:       []string{"Granary", "Storehouse"},
+			nearestDropsite: "Granary",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replay := withDropsites(testReplay(commands...), test.flags)
+			formatted, err := parser.Parse(writeReplay(t, replay), parser.ParseOptions{Stats: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			layout := (*formatted.Stats)[1].EcoLayout
+
+			dropsites := make([]string, 0)
+			for _, building := range layout.Buildings {
+				dropsites = append(dropsites, building.Name)
+			}
+			if !reflect.DeepEqual(dropsites, test.dropsites) {
+				t.Errorf("dropsites = %v, want %v", dropsites, test.dropsites)
+			}
+			if len(layout.GatherPoints) != 1 || layout.GatherPoints[0].NearestDropsite != test.nearestDropsite {
+				t.Errorf("gather points = %+v, want one nearest to the %s", layout.GatherPoints, test.nearestDropsite)
+			}
+		})
+	}
+}
@@ -1,22 +1,25 @@
 package parser
 
 import (
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"log/slog"
+	"math"
 	"strconv"
 	"strings"
 	"time"
 )
 
 func formatRawDataToReplay(
-	slim bool,
-	stats bool,
+	options ParseOptions,
 	data *[]byte,
 	rootNode *Node,
 	profileKeys *map[string]ProfileKey,
 	xmbMap *map[string]XmbFile,
 	commandList *[]RawGameCommand,
+	selections *[]SelectionSnapshot,
+	formatterInput *FormatterInput,
 ) (ReplayFormatted, error) {
 
 	buildString, err := readBuildString(data, *rootNode)
@@ -32,18 +35,20 @@ func formatRawDataToReplay(
 	}
 	majorGodMap := buildGodMap(&godsRootNode)
 
-	techTreeRootNode, err := parseXmbIfPresent(data, xmbMap, "techtree")
-	if err != nil {
-		return ReplayFormatted{}, err
-	}
-
 	losingTeams, err := getLosingTeams(commandList, profileKeys)
 	slog.Debug("Losing teams", "losingTeams", losingTeams)
 	if err != nil {
 		return ReplayFormatted{}, err
 	}
 	gameLengthSecs := (*commandList)[len(*commandList)-1].GameTimeSecs()
-	players, err := getPlayers(profileKeys, &majorGodMap, losingTeams, gameLengthSecs, commandList, &techTreeRootNode)
+	players, err := getPlayers(
+		profileKeys,
+		&majorGodMap,
+		losingTeams,
+		gameLengthSecs,
+		commandList,
+		formatterInput.techTreeRootNode,
+	)
 	if err != nil {
 		return ReplayFormatted{}, err
 	}
@@ -61,22 +66,15 @@ func formatRawDataToReplay(
 
 	gameOptions := getGameOptions(profileKeys)
 	var gameCommands []ReplayGameCommand
-	protoRootNode, err := parseXmbIfPresent(data, xmbMap, "proto")
-	if err != nil {
-		return ReplayFormatted{}, err
-	}
-	powersRootNode, err := parseXmbIfPresent(data, xmbMap, "powers")
-	if err != nil {
-		return ReplayFormatted{}, err
-	}
-	gameCommands = formatCommandsToReplayFormat(
-		commandList,
-		&players,
-		&techTreeRootNode,
-		&protoRootNode,
-		&powersRootNode,
-	)
+	// The registry is built up front so that commands referring to unit ids (e.g., delete) can be formatted with the
+	// unit's proto
+	formatterInput.units = buildUnitRegistry(commandList, selections, *formatterInput)
+	gameCommands = formatCommandsToReplayFormat(commandList, &players, *formatterInput, options.SourceUnits)
 	addTechsToPlayers(&players, &gameCommands)
+	addAgeUpsToPlayers(&players, commandList, formatterInput.techTreeRootNode, formatterInput.protoRootNode)
+	if options.OpeningRules != nil {
+		addOpeningsToPlayers(&players, commandList, *formatterInput, gameLengthSecs, options.OpeningRules)
+	}
 
 	formattedReplay := ReplayFormatted{
 		MapName:        (*profileKeys)["gamemapname"].StringVal,
@@ -90,11 +88,17 @@ func formatRawDataToReplay(
 		GameOptions:    gameOptions,
 		Players:        players,
 	}
-	if !slim {
+	if !options.Slim {
 		formattedReplay.GameCommands = &gameCommands
+		diplomacy := getDiplomacy(&gameCommands, &players, gameOptions["gamefreeforall"])
+		formattedReplay.Diplomacy = &diplomacy
 	}
-	if stats {
-		formattedReplay.Stats = calcStats(&gameCommands, commandList)
+	if options.Stats {
+		formattedReplay.Stats = calcStats(&gameCommands, commandList, *formatterInput)
+		teamTributes := calcTeamTributes(&gameCommands, &players)
+		formattedReplay.TeamTributes = &teamTributes
+		units := formatterInput.units.records()
+		formattedReplay.Units = &units
 	}
 
 	return formattedReplay, nil
@@ -139,23 +143,23 @@ func getBuildNumber(buildString string) int {
 func formatCommandsToReplayFormat(
 	commandList *[]RawGameCommand,
 	players *[]ReplayPlayer,
-	techTreeRootNode *XmbNode,
-	protoRootNode *XmbNode,
-	powers *XmbNode,
+	formatterInput FormatterInput,
+	sourceUnits bool,
 ) []ReplayGameCommand {
 	playerMap := make(map[int]ReplayPlayer)
 	for _, player := range *players {
 		playerMap[player.PlayerNum] = player
 	}
 	var replayCommands []ReplayGameCommand
-	formatterInput := FormatterInput{
-		protoRootNode:    protoRootNode,
-		techTreeRootNode: techTreeRootNode,
-		powersRootNode:   powers,
-	}
 	for _, command := range *commandList {
 		formattedCommand, ok := command.Format(formatterInput)
 		if ok {
+			if modifiers := command.Modifiers(); modifiers.Flags != 0 {
+				formattedCommand.Modifiers = &modifiers
+			}
+			if sourceUnits {
+				formattedCommand.SourceUnits = command.SourceUnits()
+			}
 			replayCommands = append(replayCommands, formattedCommand)
 		}
 	}
@@ -166,6 +170,26 @@ func formatCommandsToReplayFormat(
 	return replayCommands
 }
 
+// formatRawCommands lists every command in the stream as read, data is the buffer the command stream was parsed from
+func formatRawCommands(data *[]byte, commandList *[]RawGameCommand) []RawCommandEntry {
+	entries := make([]RawCommandEntry, 0, len(*commandList))
+	for _, command := range *commandList {
+		entries = append(entries, RawCommandEntry{
+			CommandType: command.CommandType(),
+			Name:        commandName(command.CommandType()),
+			PlayerNum:   command.PlayerId(),
+			// Same tick to game time conversion as newBaseCommand, rounded back
+			Tick:             int(math.Round(command.GameTimeSecs() * 20)),
+			Offset:           command.OffsetEnd() - command.ByteLength(),
+			ByteLength:       command.ByteLength(),
+			PreArgumentBytes: hex.EncodeToString(command.PreArgumentBytes()),
+			SourceUnits:      command.SourceUnits(),
+			Body:             hex.EncodeToString(commandBody(data, command)),
+		})
+	}
+	return entries
+}
+
 func getLosingTeams(commandList *[]RawGameCommand, profileKeys *map[string]ProfileKey) (map[int]bool, error) {
 	// Gets all resign commands and returns the set of team ids of the players who resigned
 	resigningPlayers := make(map[int]bool)
@@ -302,12 +326,12 @@ func getMinorGods(playerNum int, commandList *[]RawGameCommand, techTreeRootNode
 		}
 
 		if researchCmd, ok := command.(ResearchCommand); ok {
-			tech := techTreeRootNode.children[researchCmd.techId].attributes["name"]
+			tech := techName(techTreeRootNode, researchCmd.techId)
 			if isAgeUpTech(tech) {
 				ageUpTechs = append(ageUpTechs, tech)
 			}
 		} else if prequeueTechCmd, ok := command.(PrequeueTechCommand); ok {
-			tech := techTreeRootNode.children[prequeueTechCmd.techId].attributes["name"]
+			tech := techName(techTreeRootNode, prequeueTechCmd.techId)
 			if isAgeUpTech(tech) {
 				ageUpTechs = append(ageUpTechs, tech)
 			}
@@ -446,6 +470,27 @@ func addTechsToPlayers(players *[]ReplayPlayer, gameCommands *[]ReplayGameComman
 	}
 }
 
+// buildFormatterInput parses the XMB files that command formatters need to resolve ids into names.
+func buildFormatterInput(data *[]byte, xmbMap *map[string]XmbFile) (FormatterInput, error) {
+	techTreeRootNode, err := parseXmbIfPresent(data, xmbMap, "techtree")
+	if err != nil {
+		return FormatterInput{}, err
+	}
+	protoRootNode, err := parseXmbIfPresent(data, xmbMap, "proto")
+	if err != nil {
+		return FormatterInput{}, err
+	}
+	powersRootNode, err := parseXmbIfPresent(data, xmbMap, "powers")
+	if err != nil {
+		return FormatterInput{}, err
+	}
+	return FormatterInput{
+		protoRootNode:    &protoRootNode,
+		techTreeRootNode: &techTreeRootNode,
+		powersRootNode:   &powersRootNode,
+	}, nil
+}
+
 // parseXmbIfPresent looks up an XMB by name and parses it if it exists in the
 // replay. Game patches occasionally remove or rename embedded XMB files; rather
 // than fail the whole parse, missing entries log a warning and return a zero
@@ -0,0 +1,69 @@
+package parser_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+func TestIdsOutsideTheXmbsFormatAsUnknown(t *testing.T) {
+	replayPath := writeReplay(t, testReplay(
+		encoder.Research{CommandHeader: at(1, 20, 100), TechId: 99},
+		encoder.PrequeueTech{CommandHeader: at(1, 40), TechId: -1},
+		encoder.UseProtoPower{CommandHeader: at(1, 60), ProtoPowerId: 99},
+		encoder.Resign{CommandHeader: at(2, 80)},
+	))
+
+	formatted, err := parser.Parse(replayPath, parser.ParseOptions{Stats: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, command := range (*formatted.GameCommands)[:3] {
+		name, ok := command.Payload.(string)
+		if powerPayload, isPower := command.Payload.(parser.ProtoPowerPayload); isPower {
+			name, ok = powerPayload.Name, true
+		}
+		if !ok || name != "unknown" {
+			t.Errorf("%s payload = %#v, want an unknown name", command.CommandType, command.Payload)
+		}
+	}
+
+	if _, err := parser.ParseBuildOrder(replayPath, 0, false); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSlimOutput(t *testing.T) {
+	queued := at(1, 20, 100)
+	queued.Queued = true
+	replayPath := writeReplay(t, testReplay(
+		encoder.Train{CommandHeader: queued, ProtoUnitId: villager},
+		encoder.Train{CommandHeader: at(1, 40, 100), ProtoUnitId: villager},
+		encoder.Resign{CommandHeader: at(2, 80)},
+	))
+
+	slim, err := parser.ParseToJson(replayPath, false, parser.ParseOptions{Slim: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(slim, `"Diplomacy"`) {
+		t.Error("slim output has the diplomacy, which is built from the game commands it leaves out")
+	}
+
+	formatted, err := parser.Parse(replayPath, parser.ParseOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if formatted.Diplomacy == nil {
+		t.Error("diplomacy is missing")
+	}
+	commands := *formatted.GameCommands
+	if commands[0].Modifiers == nil || !commands[0].Modifiers.Queued {
+		t.Errorf("queued train modifiers = %+v, want queued", commands[0].Modifiers)
+	}
+	if commands[1].Modifiers != nil {
+		t.Errorf("train without modifiers has modifiers %+v", commands[1].Modifiers)
+	}
+}
@@ -38,34 +38,58 @@ func newBaseCommand(
 // that can be used.
 // =========================================================================
 
-func parseGameCommands(data *[]byte, headerEndOffset int, commandCount int) ([]RawGameCommand, error) {
-	offset := bytes.Index((*data)[headerEndOffset:], FOOTER)
-	// slog.Debug("Parsing command list", "offset", strconv.FormatInt(int64(headerEndOffset+offset), 16))
-
-	if offset == -1 {
-		return nil, FooterNotFoundError(offset)
+func parseGameCommands(
+	data *[]byte,
+	headerEndOffset int,
+	commandCount int,
+) ([]RawGameCommand, []SelectionSnapshot, error) {
+	offset, err := firstCommandListOffset(data, headerEndOffset)
+	if err != nil {
+		return nil, nil, err
 	}
-
-	offset += headerEndOffset - 19
 	commandList := make([]RawGameCommand, 0)
+	selections := make([]SelectionSnapshot, 0)
 
 	for i := 1; i <= commandCount; i++ {
-		item, err := parseCommandList(data, offset, i)
+		item, err := parseCommandList(data, offset, i, nil, nil)
 		if err != nil {
-			return commandList, err
+			return commandList, selections, err
 		}
 		// Add all the commands to the command list, flattening everything into a single list.
 		commandList = append(commandList, item.commands...)
 		if item.entryIdx != i {
-			return commandList, fmt.Errorf("entryIdx was not sequential, item.entryIdx=%v, lastIndex=%v", item.entryIdx, i)
+			return commandList, selections, fmt.Errorf(
+				"entryIdx was not sequential, item.entryIdx=%v, lastIndex=%v",
+				item.entryIdx,
+				i,
+			)
+		}
+		if item.selectedUnits != nil {
+			selections = append(selections, SelectionSnapshot{
+				// Same tick to game time conversion as newBaseCommand
+				GameTimeSecs: float64(i) / 20.0,
+				Units:        item.selectedUnits,
+			})
 		}
 		offset = item.offsetEnd
 	}
 
-	return commandList, nil
+	return commandList, selections, nil
+}
+
+// firstCommandListOffset finds where the first command list starts, it sits 19 bytes before the first footer after the
+// header.
+func firstCommandListOffset(data *[]byte, headerEndOffset int) (int, error) {
+	offset := bytes.Index((*data)[headerEndOffset:], FOOTER)
+	// slog.Debug("Parsing command list", "offset", strconv.FormatInt(int64(headerEndOffset+offset), 16))
+
+	if offset == -1 {
+		return -1, FooterNotFoundError(offset)
+	}
+	return offset + headerEndOffset - 19, nil
 }
 
-func findFooterEndOffset(data *[]byte, offset int) (int, error) {
+func findFooterEndOffset(data *[]byte, offset int, a *annotator) (int, error) {
 	/*
 		Each set of commands is followd by a "FOOTER" (footer is probably not the correct term) the demarcates the
 		end of the command sequence and the beginning of the next. This function finds end of this footer and the
@@ -74,58 +98,74 @@ func findFooterEndOffset(data *[]byte, offset int) (int, error) {
 	derefedData := *data
 	// early := derefedData[offset:offset+10]
 	extraByteCount := derefedData[offset]
+	a.add(offset, 1, false, "footer numExtraBytes=%d", extraByteCount)
 	offset += 1
 	extraByteNumbers := make([]uint8, extraByteCount)
 	for i := 0; i < int(extraByteCount); i++ {
 		extraByteNumbers[i] = derefedData[offset+i]
 	}
+	a.add(offset, int(extraByteCount), true, "footer extra bytes")
 	offset += int(extraByteCount)
 	if extraByteCount > 0 {
 		slog.Debug(fmt.Sprintf("foot has %v extra bytes: %v", extraByteCount, extraByteNumbers))
 	}
 
 	unk := derefedData[offset]
+	if unk != 0 && unk != 1 {
+		slog.Debug("unk not equal to 0 or 1", "unk", unk)
+		return -1, UnkNotExpectedValueError(offset + 1)
+	}
+	a.add(offset, 1, false, "footer unk=%d", unk)
 	offset += 1
-
 	if unk == 0 {
+		a.add(offset, 8, true, "footer unk padding")
 		offset += 8
-	} else if unk != 1 {
-		slog.Debug("unk not equal to 0 or 1", "unk", unk)
-		return -1, UnkNotExpectedValueError(offset)
 	}
 
 	oneFourthFooterLength := readUint16(data, offset)
+	a.add(offset, 4, false, "footer length=4*%d", oneFourthFooterLength)
 	offset += 4
 	endOffset := offset + 4*int(oneFourthFooterLength)
+	a.add(offset, 4*int(oneFourthFooterLength), true, "footer")
 	// late = derefedData[offset:endOffset]
 	return endOffset, nil
 }
 
-func parseCommandList(data *[]byte, offset int, lastCommandListIdx int) (CommandList, error) {
+func parseCommandList(
+	data *[]byte,
+	offset int,
+	lastCommandListIdx int,
+	lengthOverrides map[int]int,
+	a *annotator,
+) (CommandList, error) {
 	/*
 	   Parses a command list. The first int is a bit mask. Valid values:
 	   1
 	   32
 	   64
 	   128
+	   a labels the bytes for the annotated hexdump (see hexdump.go), it is nil when parsing for real.
 	*/
 	derefedData := *data
 	entryType := readUint32(data, offset)
 	slog.Debug(fmt.Sprintf("Parsing command list at offset=%v entryType=%v", strconv.FormatInt(int64(offset), 16), entryType))
-	offset += 4
-	// earlyByte = data[offset]
-	offset += 1
-
 	if entryType&225 != entryType {
 		return CommandList{}, fmt.Errorf("bad entry type, masking to 224 doesn't work for %v", entryType)
 	}
 	if entryType&96 == 96 {
 		return CommandList{}, errors.New("96 entryType does't make sense")
 	}
+	a.add(offset, 4, false, "entryType=%d", entryType)
+	offset += 4
+	// earlyByte = data[offset]
+	a.add(offset, 1, true, "earlyByte")
+	offset += 1
 
 	if entryType&1 == 0 {
+		a.add(offset, 4, true, "entryType padding")
 		offset += 4
 	} else {
+		a.add(offset, 1, true, "entryType padding")
 		offset += 1
 	}
 
@@ -135,36 +175,42 @@ func parseCommandList(data *[]byte, offset int, lastCommandListIdx int) (Command
 		numItems := 0
 		if entryType&32 != 0 {
 			numItems = int(derefedData[offset])
+			a.add(offset, 1, false, "numCommands=%d", numItems)
 			offset += 1
 		} else if entryType&64 != 0 {
 			numItems = int(readUint32(data, offset))
+			a.add(offset, 4, false, "numCommands=%d", numItems)
 			offset += 4
 		}
 
 		for i := 0; i < numItems; i++ {
-			command, err := parseGameCommand(data, offset, lastCommandListIdx)
+			command, err := parseGameCommand(data, offset, lastCommandListIdx, lengthOverrides, a)
 			if err != nil {
-				return CommandList{}, err
+				// Keep the commands parsed so far and where the failing one starts, diagnostics look at both
+				return CommandList{offsetEnd: offset, commands: commands}, err
 			}
 			commands = append(commands, command)
 			offset = command.OffsetEnd()
 		}
 	}
 
-	// TODO: Do something with selectedUints
-	// selectedUints := make([]uint32, 0)
+	// When the selection changes in a tick, the selected unit ids are stored after the commands
+	var selectedUnits []uint32
 	if entryType&128 != 0 {
 		numItems := int(derefedData[offset])
+		a.add(offset, 1, false, "numSelectedUnits=%d", numItems)
 		offset += 1
+		selectedUnits = make([]uint32, numItems)
 		for i := 0; i < numItems; i++ {
-			// selectedUints = append(selectedUints, readUint32(data, offset))
+			selectedUnits[i] = readUint32(data, offset)
+			a.add(offset, 4, false, "selectedUnit=%d", selectedUnits[i])
 			offset += 4
 		}
 	}
 
-	footerEndOffset, err := findFooterEndOffset(data, offset)
+	footerEndOffset, err := findFooterEndOffset(data, offset, a)
 	if err != nil {
-		return CommandList{}, err
+		return CommandList{commands: commands}, err
 	}
 	offset = footerEndOffset
 	// Right after the footer is the "entry index" which is basically the index of this command sequence.
@@ -172,82 +218,115 @@ func parseCommandList(data *[]byte, offset int, lastCommandListIdx int) (Command
 	// commands occurred. The game seems to run at 20hz, so each entryIdx is 1/20th of a second. At all commands
 	// in that same 1/20th of a second are grouped into the same command list.
 	entryIdx := readUint32(data, offset)
+	a.add(offset, 4, false, "entryIdx=%d", entryIdx)
 	offset += 4
 	finalByte := derefedData[offset]
 	if finalByte != 0 {
-		return CommandList{}, fmt.Errorf("final byte doesn't equal 0, finalByte=%v", finalByte)
+		return CommandList{commands: commands}, fmt.Errorf("final byte doesn't equal 0, finalByte=%v", finalByte)
 	}
+	a.add(offset, 1, false, "final byte")
 	offset += 1
 
 	return CommandList{
 		int(entryIdx),
 		offset,
 		commands,
+		selectedUnits,
 	}, nil
 }
 
-func parseGameCommand(data *[]byte, offset int, lastCommandListIdx int) (RawGameCommand, error) {
+func parseGameCommand(
+	data *[]byte,
+	offset int,
+	lastCommandListIdx int,
+	lengthOverrides map[int]int,
+	a *annotator,
+) (RawGameCommand, error) {
 	/*
 		Parses a direct game command and does some sanity checking of bytes. This commnad goes through
 		a refiner defined by the Refine function on the command type in gameCommands.go If a refiner doesn't exist
-		for the command type then this function will fail.
+		for the command type then this function will fail. lengthOverrides replaces the body length of command types
+		for layout diagnostics (see diagnose.go), it is nil when parsing for real. a labels the bytes like in
+		parseCommandList.
 	*/
 	derefedData := *data
 	commandType := int(derefedData[offset+1])
 	tenBytesOffset := offset
-	offset += 10
+	headerLength := 8
 	if commandType == 14 {
-		offset += 20
+		headerLength = 20
+	}
+	a.section("command %d (%s)", commandType, commandName(commandType))
+	a.add(offset, 1, true, "preamble")
+	a.add(offset+1, 1, false, "commandType=%d", commandType)
+	if commandType == 19 {
+		// Tributes store the player in the preamble instead of after the three
+		a.add(offset+2, 5, true, "preamble")
+		a.add(offset+7, 1, false, "playerId=%d", derefedData[offset+7])
+		a.add(offset+8, 2+headerLength, true, "preamble")
 	} else {
-		offset += 8
+		a.add(offset+2, 8+headerLength, true, "preamble")
 	}
+	offset += 10 + headerLength
 
 	three := readUint32(data, offset)
-	offset += 4
 	if three != uint32(3) {
 		return BaseCommand{}, fmt.Errorf("expecting three while parsing game command %v, three=%v", commandType, three)
 	}
+	a.add(offset, 4, false, "three")
+	offset += 4
 
 	playerId := -1
 	if commandType == 19 {
 		playerId = int(derefedData[tenBytesOffset+7])
+		a.add(offset, 4, true, "unknown")
 		offset += 4
 	} else {
 		one := readUint16(data, offset)
 		if one != uint16(1) {
 			return BaseCommand{}, fmt.Errorf("expecting one while parsing game command, one=%v", one)
 		}
+		a.add(offset, 4, false, "one")
 		offset += 4
 		playerId = int(readUint16(data, offset))
 		if playerId > 12 {
 			return BaseCommand{}, fmt.Errorf("player id must be 12 or less, playerId=%v", playerId)
 		}
+		a.add(offset, 4, false, "playerId=%d", playerId)
 		offset += 4
 	}
+	a.add(offset, 4, true, "unknown")
 	offset += 4
 	numUnits := readUint16(data, offset)
+	a.add(offset, 4, false, "numSourceUnits=%d", numUnits)
 	offset += 4
 
 	sourceUnits := make([]uint32, numUnits)
 	for i := 0; i < int(numUnits); i++ {
 		sourceUnits[i] = readUint32(data, offset)
+		a.add(offset, 4, false, "sourceUnit=%d", sourceUnits[i])
 		offset += 4
 	}
 
 	numVectors := readUint16(data, offset)
+	a.add(offset, 4, false, "numSourceVectors=%d", numVectors)
 	offset += 4
 	sourceVectors := make([]Vector3, numVectors)
 	for i := 0; i < int(numVectors); i++ {
 		sourceVectors[i] = readVector(data, offset)
+		a.add(offset, 12, false, "sourceVector=(%d, %d, %d)", sourceVectors[i].X, sourceVectors[i].Y, sourceVectors[i].Z)
 		offset += 12
 	}
 
 	numPreArgumentBytes := 13 + readUint16(data, offset)
+	a.add(offset, 4, false, "numPreArgumentBytes=13+%d", numPreArgumentBytes-13)
 	offset += 4
 	preArgumentBytes := make([]uint8, numPreArgumentBytes)
 	for i := 0; i < int(numPreArgumentBytes); i++ {
 		preArgumentBytes[i] = derefedData[offset+i]
 	}
+	a.add(offset, 1, false, "modifiers=%d", preArgumentBytes[0])
+	a.add(offset+1, int(numPreArgumentBytes)-1, true, "preArgumentBytes")
 	offset += int(numPreArgumentBytes)
 
 	refiner, exists := CommandFactoryInstance.Get(commandType)
@@ -264,8 +343,16 @@ func parseGameCommand(data *[]byte, offset int, lastCommandListIdx int) (RawGame
 		&sourceVectors,
 		&preArgumentBytes,
 	)
+	baseCmd.offsetStart = tenBytesOffset
+	if byteLength, overridden := lengthOverrides[commandType]; overridden {
+		// The refiner's field offsets don't apply to a body of a different length, so skip it
+		enrichBaseCommand(&baseCmd, byteLength)
+		a.add(offset, byteLength, true, "body")
+		return baseCmd, nil
+	}
 	// slog.Debug(fmt.Sprintf("Parsing game command with type=%v at offset=%v", commandType, strconv.FormatInt(int64(offset), 16)))
 	gameCommand := refiner(&baseCmd, data)
+	a.body(commandType, offset, gameCommand.ByteLength())
 	offset += gameCommand.ByteLength()
 
 	// slog.Debug(fmt.Sprintf("Parsing game command with type=%v for player playerId=%v", commandType, playerId))
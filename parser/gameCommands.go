@@ -85,24 +85,6 @@ func BuildCommandFactory() *CommandFactory {
 // use it everywhere
 var CommandFactoryInstance = BuildCommandFactory()
 
-// Utility functions for easily getting the bytes consumed by each type of input without doing any parsing.
-// used to keep track of commands where we know the types, but don't want to implement a full refiner yet.
-func unpackInt32() int {
-	return 4
-}
-
-func unpackInt8() int {
-	return 1
-}
-
-func unpackVector() int {
-	return 12
-}
-
-func unpackFloat() int {
-	return 4
-}
-
 // ===============================
 // RawGameCommand types
 // ===============================
@@ -111,6 +93,8 @@ type FormatterInput struct {
 	protoRootNode    *XmbNode
 	techTreeRootNode *XmbNode
 	powersRootNode   *XmbNode
+	// units is the unit registry used to resolve unit ids to protos, it is nil when the registry wasn't built
+	units *unitRegistry
 }
 
 // protoName resolves a unit/building proto id to its human-readable name. The
@@ -123,13 +107,26 @@ func protoName(node *XmbNode, id int32) string {
 	return node.children[id].attributes["name"]
 }
 
+// techName resolves a techId to its name, returning "unknown" for ids outside the techtree like protoName does
+func techName(node *XmbNode, id int32) string {
+	tech := techNode(node, id)
+	if tech == nil {
+		return "unknown"
+	}
+	return tech.attributes["name"]
+}
+
 type RawGameCommand interface {
 	CommandType() int
+	OffsetStart() int
 	OffsetEnd() int
 	PlayerId() int
 	ByteLength() int
 	GameTimeSecs() float64
 	AffectsEAPM() bool
+	SourceUnits() []uint32
+	PreArgumentBytes() []uint8
+	Modifiers() CommandModifiers
 	Format(input FormatterInput) (ReplayGameCommand, bool)
 }
 
@@ -141,6 +138,7 @@ type RefineableCommand interface {
 type BaseCommand struct {
 	commandType      int
 	playerId         int
+	offsetStart      int // Where the command starts, offset is where its body starts
 	offset           int
 	offsetEnd        int
 	byteLength       int
@@ -155,6 +153,10 @@ func (cmd BaseCommand) CommandType() int {
 	return cmd.commandType
 }
 
+func (cmd BaseCommand) OffsetStart() int {
+	return cmd.offsetStart
+}
+
 func (cmd BaseCommand) OffsetEnd() int {
 	return cmd.offsetEnd
 }
@@ -175,6 +177,47 @@ func (cmd BaseCommand) AffectsEAPM() bool {
 	return cmd.affectsEAPM
 }
 
+func (cmd BaseCommand) SourceUnits() []uint32 {
+	if cmd.sourceUnits == nil {
+		return nil
+	}
+	return *cmd.sourceUnits
+}
+
+func (cmd BaseCommand) PreArgumentBytes() []uint8 {
+	if cmd.preArgumentBytes == nil {
+		return nil
+	}
+	return *cmd.preArgumentBytes
+}
+
+// Bits of the first preargument byte. Every command carries at least 13 preargument bytes, the first one holds the
+// modifier keys the player held while issuing the command.
+const (
+	repeatModifierFlag uint8 = 1 // ctrl, repeat the order (e.g., keep training or keep attacking)
+	queuedModifierFlag uint8 = 2 // shift, add the order to the end of the queue instead of replacing it
+)
+
+// CommandModifiers are the modifier flags decoded from the preargument bytes. Flags is the raw byte, so bits we don't
+// know the meaning of yet are still available.
+type CommandModifiers struct {
+	Queued bool
+	Repeat bool
+	Flags  uint8
+}
+
+func (cmd BaseCommand) Modifiers() CommandModifiers {
+	if cmd.preArgumentBytes == nil || len(*cmd.preArgumentBytes) == 0 {
+		return CommandModifiers{}
+	}
+	flags := (*cmd.preArgumentBytes)[0]
+	return CommandModifiers{
+		Queued: flags&queuedModifierFlag != 0,
+		Repeat: flags&repeatModifierFlag != 0,
+		Flags:  flags,
+	}
+}
+
 func (cmd BaseCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
 	return ReplayGameCommand{}, false
 }
@@ -194,27 +237,50 @@ func enrichBaseCommand(baseCommand *BaseCommand, byteLength int) {
 
 type TaskCommand struct {
 	BaseCommand
+	targetUnitId   int32
+	targetLocation Vector3
+	targetRange    float32
+	queued         bool
 }
 
 func (cmd TaskCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{
-		unpackInt32,
-		unpackInt32,
-		unpackInt32,
-		unpackInt32,
-		unpackVector,
-		unpackFloat,
-		unpackInt32,
-		unpackInt32,
-		unpackInt32,
-	}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The task command is 44 bytes in length, consisting of 4 int32s, 1 vector, 1 float and 3 int32s. Tasks are the
+	// move/attack/gather/garrison orders, the units receiving the order are the source units of the command. The 3rd
+	// int32 is the unit id of the target (-1 when the order is to a location), the vector is the target location and
+	// the float is the range the units were ordered to act from. Like build commands, the queued (shift) flag is
+	// stored in the preargument bytes.
+	layout := currentLayout(0)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	return TaskCommand{
+		BaseCommand:    *baseCommand,
+		targetUnitId:   readInt32(data, baseCommand.offset+layout.offsetOf("targetUnitId")),
+		targetLocation: readVector(data, baseCommand.offset+layout.offsetOf("targetLocation")),
+		targetRange:    readFloat(data, baseCommand.offset+layout.offsetOf("targetRange")),
+		queued:         baseCommand.Modifiers().Queued,
 	}
-	cmd.byteLength = byteLength
-	enrichBaseCommand(baseCommand, byteLength)
-	return TaskCommand{*baseCommand}
+}
+
+type TaskPayload struct {
+	TargetUnitId   int32
+	TargetLocation Vector3
+	Range          float32
+	Queued         bool
+	SourceUnits    []uint32
+}
+
+func (cmd TaskCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "task",
+		Payload: TaskPayload{
+			TargetUnitId:   cmd.targetUnitId,
+			TargetLocation: cmd.targetLocation,
+			Range:          cmd.targetRange,
+			Queued:         cmd.queued,
+			SourceUnits:    cmd.SourceUnits(),
+		},
+	}, true
 }
 
 // ========================================================================
@@ -229,12 +295,11 @@ type ResearchCommand struct {
 func (cmd ResearchCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// The research command is 12 bytes in length, the last 4 bytes are an int32 representing the id of the tech
 	// that was researched. The id maps to a string via the techtree XMB data stored in the header of the replay.
-	// inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32}
-	byteLength := 12
-	enrichBaseCommand(baseCommand, byteLength)
+	layout := currentLayout(1)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
 	return ResearchCommand{
 		BaseCommand: *baseCommand,
-		techId:      readInt32(data, baseCommand.offset+8),
+		techId:      readInt32(data, baseCommand.offset+layout.offsetOf("techId")),
 	}
 }
 
@@ -243,7 +308,7 @@ func (cmd ResearchCommand) Format(input FormatterInput) (ReplayGameCommand, bool
 		GameTimeSecs: cmd.GameTimeSecs(),
 		PlayerNum:    cmd.PlayerId(),
 		CommandType:  "research",
-		Payload:      input.techTreeRootNode.children[cmd.techId].attributes["name"],
+		Payload:      techName(input.techTreeRootNode, cmd.techId),
 	}, true
 }
 
@@ -258,12 +323,12 @@ type TrainCommand struct {
 }
 
 func (cmd TrainCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	// The train commands contains 4 Int32s (16 bytes) and 2 Int8s (2 bytes). The 3rd, Int32 is the protoUnitId,
-	// and the last Int8 is the number of units queued.
-	byteLength := 18
-	enrichBaseCommand(baseCommand, byteLength)
-	protoUnitId := readInt32(data, baseCommand.offset+8)
-	numUnits := int8((*data)[baseCommand.offset+18])
+	// The train commands contains 4 Int32s (16 bytes) and 2 Int8s (2 bytes). The 3rd, Int32 is the protoUnitId and
+	// the last Int8 is the number of units queued, 5 for a shift-click.
+	layout := currentLayout(2)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	protoUnitId := readInt32(data, baseCommand.offset+layout.offsetOf("protoUnitId"))
+	numUnits := int8((*data)[baseCommand.offset+layout.offsetOf("numUnits")])
 	return TrainCommand{
 		BaseCommand: *baseCommand,
 		protoUnitId: protoUnitId,
@@ -271,6 +336,14 @@ func (cmd TrainCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCo
 	}
 }
 
+// batchSize is the number of units the click queued, at least 1
+func (cmd TrainCommand) batchSize() int {
+	if cmd.numUnits < 1 {
+		return 1
+	}
+	return int(cmd.numUnits)
+}
+
 func (cmd TrainCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
 	proto := protoName(input.protoRootNode, cmd.protoUnitId)
 	return ReplayGameCommand{
@@ -295,13 +368,13 @@ type BuildCommand struct {
 func (cmd BuildCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// The build command is 52 bytes in length, consisting of the following values in sequence:
 	// 4 int32s, 1 vector, 2 int32s 1 float, 4 int32s
-	byteLength := 52
-	enrichBaseCommand(baseCommand, byteLength)
-	// queued attribute comes from "preargument bytes", we will leave it as false for now
+	layout := currentLayout(3)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	// queued attribute comes from "preargument bytes", see CommandModifiers
 	// protoUnitId comes from the 3rd int32 in the command
-	protoBuildingId := readInt32(data, baseCommand.offset+8)
-	location := readVector(data, baseCommand.offset+12)
-	queued := (*baseCommand.preArgumentBytes)[0]&2 != 0
+	protoBuildingId := readInt32(data, baseCommand.offset+layout.offsetOf("protoBuildingId"))
+	location := readVector(data, baseCommand.offset+layout.offsetOf("location"))
+	queued := baseCommand.Modifiers().Queued
 	return BuildCommand{
 		BaseCommand:     *baseCommand,
 		protoBuildingId: protoBuildingId,
@@ -336,18 +409,46 @@ func (cmd BuildCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
 
 type SetGatherPointCommand struct {
 	BaseCommand
+	targetUnitId int32
+	location     Vector3
+	targetRange  float32
 }
 
 func (cmd SetGatherPointCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackVector, unpackFloat, unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	// The setGatherPoint command is 32 bytes in length, consisting of 2 int32s, 1 vector, 1 float and 2 int32s. The
+	// source units are the buildings whose gather point is set. The 2nd int32 is the unit (or resource) the gather
+	// point was put on, -1 for a spot on the ground, the vector is the gather point and the float its range.
+	layout := currentLayout(4)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
 	// Currently this command triggers a Task subtype move command immediately afterwards, so we don't want to double count
 	baseCommand.affectsEAPM = false
-	return SetGatherPointCommand{*baseCommand}
+	return SetGatherPointCommand{
+		BaseCommand:  *baseCommand,
+		targetUnitId: readInt32(data, baseCommand.offset+layout.offsetOf("targetUnitId")),
+		location:     readVector(data, baseCommand.offset+layout.offsetOf("location")),
+		targetRange:  readFloat(data, baseCommand.offset+layout.offsetOf("targetRange")),
+	}
+}
+
+type SetGatherPointPayload struct {
+	TargetUnitId int
+	Location     Vector3
+	Range        float32
+	Buildings    []uint32
+}
+
+func (cmd SetGatherPointCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "setGatherPoint",
+		Payload: SetGatherPointPayload{
+			TargetUnitId: int(cmd.targetUnitId),
+			Location:     cmd.location,
+			Range:        cmd.targetRange,
+			Buildings:    cmd.SourceUnits(),
+		},
+	}, true
 }
 
 // ========================================================================
@@ -356,16 +457,57 @@ func (cmd SetGatherPointCommand) Refine(baseCommand *BaseCommand, data *[]byte)
 
 type DeleteCommand struct {
 	BaseCommand
+	unitId int32
 }
 
 func (cmd DeleteCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt8}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The delete command is 9 bytes in length, consisting of 2 int32s and 1 int8. The units being deleted are the
+	// source units of the command, the 1st int32 is a single unit id to delete or -1 when only the source units are.
+	layout := currentLayout(7)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	return DeleteCommand{
+		BaseCommand: *baseCommand,
+		unitId:      readInt32(data, baseCommand.offset+layout.offsetOf("unitId")),
 	}
-	enrichBaseCommand(baseCommand, byteLength)
-	return DeleteCommand{*baseCommand}
+}
+
+// deletedUnits returns the ids of every unit removed by this delete command
+func (cmd DeleteCommand) deletedUnits() []uint32 {
+	units := append([]uint32{}, cmd.SourceUnits()...)
+	if cmd.unitId >= 0 {
+		for _, unitId := range units {
+			if unitId == uint32(cmd.unitId) {
+				return units
+			}
+		}
+		units = append(units, uint32(cmd.unitId))
+	}
+	return units
+}
+
+type DeletedUnit struct {
+	UnitId uint32
+	Proto  string
+}
+
+type DeletePayload struct {
+	Units []DeletedUnit
+}
+
+func (cmd DeleteCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	units := make([]DeletedUnit, 0)
+	for _, unitId := range cmd.deletedUnits() {
+		units = append(units, DeletedUnit{
+			UnitId: unitId,
+			Proto:  input.units.protoOf(cmd.PlayerId(), unitId, cmd.GameTimeSecs()),
+		})
+	}
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "delete",
+		Payload:      DeletePayload{Units: units},
+	}, true
 }
 
 // ========================================================================
@@ -377,12 +519,7 @@ type StopCommand struct {
 }
 
 func (cmd StopCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(9).ByteLength)
 	return StopCommand{*baseCommand}
 }
 
@@ -407,11 +544,11 @@ func (cmd ProtoPowerCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawG
 	//
 	// The two vectors are the target locations of the power being used, if it has a location. If it has a second
 	// location (e.g., shifting sands, underworld, etc...) the second vector will be the second location.
-	byteLength := 57
-	enrichBaseCommand(baseCommand, byteLength)
-	protoPowerId := readInt32(data, baseCommand.offset+52)
-	location1 := readVector(data, baseCommand.offset+12)
-	location2 := readVector(data, baseCommand.offset+24)
+	layout := currentLayout(12)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	protoPowerId := readInt32(data, baseCommand.offset+layout.offsetOf("protoPowerId"))
+	location1 := readVector(data, baseCommand.offset+layout.offsetOf("location1"))
+	location2 := readVector(data, baseCommand.offset+layout.offsetOf("location2"))
 	return ProtoPowerCommand{
 		BaseCommand:  *baseCommand,
 		protoPowerId: protoPowerId,
@@ -427,19 +564,19 @@ type ProtoPowerPayload struct {
 }
 
 func (cmd ProtoPowerCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
-	power := input.powersRootNode.children[cmd.protoPowerId]
-	var commandType string
-	if _, ok := power.attributes["godpower"]; ok {
-		commandType = "godPower"
-	} else {
-		commandType = "protoPower"
+	// Powers are looked up like protos, ids outside the powers XMB are formatted as an unknown proto power
+	commandType := "protoPower"
+	if power := protoNode(input.powersRootNode, cmd.protoPowerId); power != nil {
+		if _, ok := power.attributes["godpower"]; ok {
+			commandType = "godPower"
+		}
 	}
 	return ReplayGameCommand{
 		GameTimeSecs: cmd.GameTimeSecs(),
 		PlayerNum:    cmd.PlayerId(),
 		CommandType:  commandType,
 		Payload: ProtoPowerPayload{
-			Name:      power.attributes["name"],
+			Name:      protoName(input.powersRootNode, cmd.protoPowerId),
 			Location1: cmd.location1,
 			Location2: cmd.location2,
 		},
@@ -460,11 +597,32 @@ const (
 type ResourceType string
 
 const (
+	GoldResource    ResourceType = "gold"
 	FoodResource    ResourceType = "food"
 	WoodResource    ResourceType = "wood"
+	FavorResource   ResourceType = "favor"
 	UnknownResource ResourceType = "unknown"
 )
 
+// resourceTypeFromId maps the resource ids used in commands to a ResourceType. These are not in the order the game
+// lists resources in (food, wood, gold, favor). Wood (1) and food (2) were read off market commands, gold (0) can't be
+// traded at the market and is the remaining id below them, and favor (3) follows as the last resource.
+func resourceTypeFromId(resourceId int32) ResourceType {
+	switch resourceId {
+	case 0:
+		return GoldResource
+	case 1:
+		return WoodResource
+	case 2:
+		return FoodResource
+	case 3:
+		return FavorResource
+	default:
+		slog.Warn("Unknown resource type", "resourceId", resourceId)
+		return UnknownResource
+	}
+}
+
 type BuySellResourcesCommand struct {
 	BaseCommand
 	resourceType ResourceType
@@ -475,21 +633,11 @@ type BuySellResourcesCommand struct {
 func (cmd BuySellResourcesCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// marketBuySellResources is 20 bytes in length, consisting of 4 int32s and 1 float. The 3rd int32 is the
 	// resource type and the float is how much of that resource is being bought/sold
-	byteLength := 20
-	enrichBaseCommand(baseCommand, byteLength)
-	resourceId := readInt32(data, baseCommand.offset+8)
-
-	var resourceType ResourceType
-	if resourceId == 1 {
-		resourceType = WoodResource
-	} else if resourceId == 2 {
-		resourceType = FoodResource
-	} else {
-		slog.Warn("Unknown resource type", "resourceId", resourceId)
-		resourceType = UnknownResource
-	}
+	layout := currentLayout(13)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	resourceType := resourceTypeFromId(readInt32(data, baseCommand.offset+layout.offsetOf("resourceType")))
 
-	quantity := readFloat(data, baseCommand.offset+16)
+	quantity := readFloat(data, baseCommand.offset+layout.offsetOf("quantity"))
 	action := BuyAction
 	if quantity < 0 {
 		action = SellAction
@@ -529,16 +677,38 @@ func (cmd BuySellResourcesCommand) Format(input FormatterInput) (ReplayGameComma
 
 type UngarrisonCommand struct {
 	BaseCommand
+	unitId int32
 }
 
 func (cmd UngarrisonCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The ungarrison command is 8 bytes in length, consisting of 2 int32s. The source units are the buildings (or
+	// ships) being emptied and the 1st int32 is the unit to let out, -1 when everything inside is let out. Note that
+	// ungarrison commands have 20 extra bytes in the command header, see parseGameCommand.
+	layout := currentLayout(14)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	return UngarrisonCommand{
+		BaseCommand: *baseCommand,
+		unitId:      readInt32(data, baseCommand.offset+layout.offsetOf("unitId")),
 	}
-	enrichBaseCommand(baseCommand, byteLength)
-	return UngarrisonCommand{*baseCommand}
+}
+
+type UngarrisonPayload struct {
+	UnitId    int
+	All       bool
+	Buildings []uint32
+}
+
+func (cmd UngarrisonCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "ungarrison",
+		Payload: UngarrisonPayload{
+			UnitId:    int(cmd.unitId),
+			All:       cmd.unitId < 0,
+			Buildings: cmd.SourceUnits(),
+		},
+	}, true
 }
 
 // ========================================================================
@@ -550,13 +720,7 @@ type ResignCommand struct {
 }
 
 func (cmd ResignCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32, unpackInt32, unpackInt32, unpackInt8}
-	byteLength := 0
-	for _, f := range inputTypes {
-		//slog.Debug("resign", "f", readUint32(data, baseCommand.offset+byteLength))
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(16).ByteLength)
 	baseCommand.affectsEAPM = false
 	return ResignCommand{*baseCommand}
 }
@@ -579,12 +743,7 @@ type UnknownCommand18 struct {
 }
 
 func (cmd UnknownCommand18) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(18).ByteLength)
 	return UnknownCommand18{*baseCommand}
 }
 
@@ -594,16 +753,48 @@ func (cmd UnknownCommand18) Refine(baseCommand *BaseCommand, data *[]byte) RawGa
 
 type TributeCommand struct {
 	BaseCommand
+	recipient    int32
+	resourceType ResourceType
+	amount       float32
+	tax          float32
 }
 
 func (cmd TributeCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32, unpackInt32, unpackFloat, unpackFloat, unpackInt8}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The tribute command is 25 bytes in length, consisting of 4 int32s, 2 floats and 1 int8. The 3rd int32 is the
+	// player receiving the tribute and the 4th is the resource type. The first float is the amount sent and the
+	// second is the tribute tax as a rate between 0 and 1, the recipient gets amount * (1 - tax).
+	// Note that the player id of tribute commands is stored in a different place in the command header, see
+	// parseGameCommand.
+	layout := currentLayout(19)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	return TributeCommand{
+		BaseCommand:  *baseCommand,
+		recipient:    readInt32(data, baseCommand.offset+layout.offsetOf("recipient")),
+		resourceType: resourceTypeFromId(readInt32(data, baseCommand.offset+layout.offsetOf("resourceType"))),
+		amount:       readFloat(data, baseCommand.offset+layout.offsetOf("amount")),
+		tax:          readFloat(data, baseCommand.offset+layout.offsetOf("tax")),
 	}
-	enrichBaseCommand(baseCommand, byteLength)
-	return TributeCommand{*baseCommand}
+}
+
+type TributePayload struct {
+	Recipient    int
+	ResourceType string
+	Amount       float32
+	Tax          float32
+}
+
+func (cmd TributeCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "tribute",
+		Payload: TributePayload{
+			Recipient:    int(cmd.recipient),
+			ResourceType: string(cmd.resourceType),
+			Amount:       cmd.amount,
+			Tax:          cmd.tax,
+		},
+	}, true
 }
 
 // ========================================================================
@@ -612,52 +803,174 @@ func (cmd TributeCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGame
 
 type FinishUnitTransformCommand struct {
 	BaseCommand
+	unitId      int32
+	protoUnitId int32
 }
 
 func (cmd FinishUnitTransformCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32, unpackInt32, unpackInt8, unpackInt8}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The finishUnitTransform command is 18 bytes in length, consisting of 4 int32s and 2 int8s. The 2nd int32 is the
+	// protoUnitId the unit transformed into and the 3rd int32 is the id of the unit that finished transforming.
+	layout := currentLayout(23)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	return FinishUnitTransformCommand{
+		BaseCommand: *baseCommand,
+		protoUnitId: readInt32(data, baseCommand.offset+layout.offsetOf("protoUnitId")),
+		unitId:      readInt32(data, baseCommand.offset+layout.offsetOf("unitId")),
+	}
+}
+
+type TransformPayload struct {
+	UnitId   int
+	From     string
+	To       string
+	Finished bool
+}
+
+func (cmd FinishUnitTransformCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return formatTransform(cmd.BaseCommand, cmd.unitId, cmd.protoUnitId, true, input), true
+}
+
+// formatTransform formats both halves of a unit transformation. The proto the unit transformed from is the one the
+// unit registry inferred for it, the proto it transforms into is read from the command.
+func formatTransform(
+	baseCommand BaseCommand,
+	unitId int32,
+	protoUnitId int32,
+	finished bool,
+	input FormatterInput,
+) ReplayGameCommand {
+	from := "unknown"
+	if unitId >= 0 {
+		from = input.units.protoOf(baseCommand.PlayerId(), uint32(unitId), baseCommand.GameTimeSecs())
+	}
+	return ReplayGameCommand{
+		GameTimeSecs: baseCommand.GameTimeSecs(),
+		PlayerNum:    baseCommand.PlayerId(),
+		CommandType:  "transform",
+		Payload: TransformPayload{
+			UnitId:   int(unitId),
+			From:     from,
+			To:       protoName(input.protoRootNode, protoUnitId),
+			Finished: finished,
+		},
 	}
-	enrichBaseCommand(baseCommand, byteLength)
-	return FinishUnitTransformCommand{*baseCommand}
 }
 
 // ========================================================================
 // 25 - setUnitStance
 // ========================================================================
 
+type UnitStance string
+
+const (
+	AggressiveUnitStance  UnitStance = "aggressive"
+	DefensiveUnitStance   UnitStance = "defensive"
+	StandGroundUnitStance UnitStance = "standGround"
+	NoAttackUnitStance    UnitStance = "noAttack"
+	UnknownUnitStance     UnitStance = "unknown"
+)
+
 type SetUnitStanceCommand struct {
 	BaseCommand
+	stance UnitStance
 }
 
 func (cmd SetUnitStanceCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt8, unpackInt8, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The setUnitStance command is 14 bytes in length, consisting of 2 int32s, 2 int8s and 1 int32. The source units
+	// are the units changing stance and the 1st int8 is the stance. The rest is unknown.
+	layout := currentLayout(25)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+
+	stanceId := (*data)[baseCommand.offset+layout.offsetOf("stance")]
+	var stance UnitStance
+	switch stanceId {
+	case 0:
+		stance = AggressiveUnitStance
+	case 1:
+		stance = DefensiveUnitStance
+	case 2:
+		stance = StandGroundUnitStance
+	case 3:
+		stance = NoAttackUnitStance
+	default:
+		stance = UnknownUnitStance
+		slog.Warn("Unknown unit stance", "stanceId", stanceId)
+	}
+
+	return SetUnitStanceCommand{
+		BaseCommand: *baseCommand,
+		stance:      stance,
 	}
-	enrichBaseCommand(baseCommand, byteLength)
-	return SetUnitStanceCommand{*baseCommand}
+}
+
+func (cmd SetUnitStanceCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "stance",
+		Payload:      string(cmd.stance),
+	}, true
 }
 
 // ========================================================================
 // 26 - changeDiplomacy
 // ========================================================================
 
+type DiplomacyStance string
+
+const (
+	AllyStance    DiplomacyStance = "ally"
+	NeutralStance DiplomacyStance = "neutral"
+	EnemyStance   DiplomacyStance = "enemy"
+	UnknownStance DiplomacyStance = "unknown"
+)
+
 type ChangeDiplomacyCommand struct {
 	BaseCommand
+	stance       DiplomacyStance
+	targetPlayer int32
 }
 
 func (cmd ChangeDiplomacyCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt8, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The changeDiplomacy command is 13 bytes in length, consisting of 2 int32s, 1 int8 and 1 int32. The int8 is the
+	// new stance towards the player in the last int32.
+	layout := currentLayout(26)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	stanceId := (*data)[baseCommand.offset+layout.offsetOf("stance")]
+	var stance DiplomacyStance
+	switch stanceId {
+	case 0:
+		stance = AllyStance
+	case 1:
+		stance = NeutralStance
+	case 2:
+		stance = EnemyStance
+	default:
+		stance = UnknownStance
+		slog.Warn("Unknown diplomacy stance", "stanceId", stanceId)
+	}
+	return ChangeDiplomacyCommand{
+		BaseCommand:  *baseCommand,
+		stance:       stance,
+		targetPlayer: readInt32(data, baseCommand.offset+layout.offsetOf("targetPlayer")),
 	}
-	enrichBaseCommand(baseCommand, byteLength)
-	return ChangeDiplomacyCommand{*baseCommand}
+}
+
+type DiplomacyPayload struct {
+	TargetPlayer int
+	Stance       string
+}
+
+func (cmd ChangeDiplomacyCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "diplomacy",
+		Payload: DiplomacyPayload{
+			TargetPlayer: int(cmd.targetPlayer),
+			Stance:       string(cmd.stance),
+		},
+	}, true
 }
 
 // ========================================================================
@@ -670,8 +983,7 @@ type TownBellCommand struct {
 
 func (cmd TownBellCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// The townBell command is 8 bytes long, consisting of 2 int32s.
-	byteLength := 8
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(34).ByteLength)
 	return TownBellCommand{
 		BaseCommand: *baseCommand,
 	}
@@ -696,8 +1008,7 @@ type AutoScoutEventCommand struct {
 
 func (cmd AutoScoutEventCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// The autoScoutEvent is 12 bytes long, consisting of 3 int32s.
-	byteLength := 12
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(35).ByteLength)
 	baseCommand.affectsEAPM = false
 	return AutoScoutEventCommand{*baseCommand}
 }
@@ -706,21 +1017,85 @@ func (cmd AutoScoutEventCommand) Refine(baseCommand *BaseCommand, data *[]byte)
 // 37 - changeControlGroupContents
 // ========================================================================
 
+type ControlGroupOperation string
+
+const (
+	RemoveFromControlGroup       ControlGroupOperation = "remove"
+	AddToControlGroup            ControlGroupOperation = "add"
+	SetControlGroup              ControlGroupOperation = "set"
+	UnknownControlGroupOperation ControlGroupOperation = "unknown"
+)
+
 type ChangeControlGroupContentsCommand struct {
 	BaseCommand
+	group     int32
+	unitId    int32
+	operation ControlGroupOperation
 }
 
 func (cmd ChangeControlGroupContentsCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt8, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
-	// Every time you change a control group, the game triggers one event per unit in the group (removing them) and then readds them all, with 1 event per unit
-	// Including this would inflate CPM by a LOT.
+	// The changeControlGroupContents command is 13 bytes in length, consisting of 2 int32s, 1 int8 and 1 int32. The
+	// 1st int32 is the control group number, the 2nd the unit being added or removed and the int8 the operation
+	// (0 remove, 1 add, 2 set). The last int32 is unknown.
+	layout := currentLayout(37)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	// Every time you change a control group, the game triggers one event per unit in the group (removing them) and
+	// then readds them all, with 1 event per unit. Including this would inflate CPM by a LOT.
 	baseCommand.affectsEAPM = false
-	return ChangeControlGroupContentsCommand{*baseCommand}
+
+	operationId := (*data)[baseCommand.offset+layout.offsetOf("operation")]
+	var operation ControlGroupOperation
+	switch operationId {
+	case 0:
+		operation = RemoveFromControlGroup
+	case 1:
+		operation = AddToControlGroup
+	case 2:
+		operation = SetControlGroup
+	default:
+		operation = UnknownControlGroupOperation
+		slog.Warn("Unknown control group operation", "operationId", operationId)
+	}
+
+	return ChangeControlGroupContentsCommand{
+		BaseCommand: *baseCommand,
+		group:       readInt32(data, baseCommand.offset+layout.offsetOf("group")),
+		unitId:      readInt32(data, baseCommand.offset+layout.offsetOf("unitId")),
+		operation:   operation,
+	}
+}
+
+type ControlGroupPayload struct {
+	Group     int
+	Operation string
+	UnitIds   []uint32
+}
+
+// unitIds returns the units added to or removed from the control group by this command
+func (cmd ChangeControlGroupContentsCommand) unitIds() []uint32 {
+	units := append([]uint32{}, cmd.SourceUnits()...)
+	if cmd.unitId >= 0 {
+		for _, unitId := range units {
+			if unitId == uint32(cmd.unitId) {
+				return units
+			}
+		}
+		units = append(units, uint32(cmd.unitId))
+	}
+	return units
+}
+
+func (cmd ChangeControlGroupContentsCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "controlGroup",
+		Payload: ControlGroupPayload{
+			Group:     int(cmd.group),
+			Operation: string(cmd.operation),
+			UnitIds:   cmd.unitIds(),
+		},
+	}, true
 }
 
 // ========================================================================
@@ -732,12 +1107,7 @@ type RepairCommand struct {
 }
 
 func (cmd RepairCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(38).ByteLength)
 	return RepairCommand{*baseCommand}
 }
 
@@ -750,12 +1120,7 @@ type UnknownCommand39 struct {
 }
 
 func (cmd UnknownCommand39) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(39).ByteLength)
 	return UnknownCommand39{*baseCommand}
 }
 
@@ -771,9 +1136,9 @@ type TauntCommand struct {
 func (cmd TauntCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// The taunt command is 45 bytes long, consisting of 11 int32s, followed by 1 int8.
 	// The 3rd int32 is the tauntIdo.
-	byteLength := 45
-	enrichBaseCommand(baseCommand, byteLength)
-	tauntId := readInt32(data, baseCommand.offset+8)
+	layout := currentLayout(41)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	tauntId := readInt32(data, baseCommand.offset+layout.offsetOf("tauntId"))
 	return TauntCommand{
 		BaseCommand: *baseCommand,
 		tauntId:     tauntId,
@@ -801,9 +1166,9 @@ type CheatCommand struct {
 func (cmd CheatCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// Cheat command is 16 bytes long, consisting of 4 int32s. The 3rd int32 is the cheatId. Which can be
 	// converted to a string via XMB data
-	byteLength := 16
-	enrichBaseCommand(baseCommand, byteLength)
-	cheatId := readInt32(data, baseCommand.offset+8)
+	layout := currentLayout(44)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	cheatId := readInt32(data, baseCommand.offset+layout.offsetOf("cheatId"))
 	return CheatCommand{
 		BaseCommand: *baseCommand,
 		cheatId:     cheatId,
@@ -826,16 +1191,68 @@ func (cmd CheatCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
 
 type CancelQueuedItemCommand struct {
 	BaseCommand
+	buildingId int32
+	itemId     int32
+	queueSlot  int32
+	// itemKind and cancelledCommandIdx, the index of the click that queued the cancelled item in the command list (-1
+	// when unresolved), are filled in after the whole command stream is parsed, see resolveCancelledItems
+	itemKind            QueuedItemKind
+	cancelledCommandIdx int
 }
 
+type QueuedItemKind string
+
+const (
+	QueuedUnit    QueuedItemKind = "unit"
+	QueuedTech    QueuedItemKind = "tech"
+	QueuedUnknown QueuedItemKind = "unknown"
+)
+
 func (cmd CancelQueuedItemCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32, unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The cancelQueuedItem command is 20 bytes in length, consisting of 5 int32s. The 3rd int32 is the unit id of the
+	// building whose queue is being modified, the 4th is the id of the queued item and the 5th is the slot in the
+	// queue. The item id is either a protoUnitId (trains) or a techId (research/prequeues), which one can only be
+	// told apart by matching it against what was queued in that building earlier.
+	layout := currentLayout(45)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	return CancelQueuedItemCommand{
+		BaseCommand:         *baseCommand,
+		buildingId:          readInt32(data, baseCommand.offset+layout.offsetOf("buildingId")),
+		itemId:              readInt32(data, baseCommand.offset+layout.offsetOf("itemId")),
+		queueSlot:           readInt32(data, baseCommand.offset+layout.offsetOf("queueSlot")),
+		itemKind:            QueuedUnknown,
+		cancelledCommandIdx: -1,
 	}
-	enrichBaseCommand(baseCommand, byteLength)
-	return CancelQueuedItemCommand{*baseCommand}
+}
+
+type CancelQueuedItemPayload struct {
+	Name       string
+	Kind       string
+	BuildingId int32
+	QueueSlot  int32
+}
+
+func (cmd CancelQueuedItemCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	name := "unknown"
+	switch cmd.itemKind {
+	case QueuedUnit:
+		name = protoName(input.protoRootNode, cmd.itemId)
+	case QueuedTech:
+		if node := techNode(input.techTreeRootNode, cmd.itemId); node != nil {
+			name = node.attributes["name"]
+		}
+	}
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "cancelQueuedItem",
+		Payload: CancelQueuedItemPayload{
+			Name:       name,
+			Kind:       string(cmd.itemKind),
+			BuildingId: cmd.buildingId,
+			QueueSlot:  cmd.queueSlot,
+		},
+	}, true
 }
 
 // ========================================================================
@@ -849,9 +1266,9 @@ type SetFormationCommand struct {
 
 func (cmd SetFormationCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// The setFormation command is 16 bytes in length, consisting of 4 int32s. The 3rd int32 is the formationId
-	byteLength := 16
-	enrichBaseCommand(baseCommand, byteLength)
-	formationId := readInt32(data, baseCommand.offset+8)
+	layout := currentLayout(48)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	formationId := readInt32(data, baseCommand.offset+layout.offsetOf("formationId"))
 	var formation string
 	switch formationId {
 	case 0:
@@ -886,18 +1303,26 @@ func (cmd SetFormationCommand) Format(input FormatterInput) (ReplayGameCommand,
 
 type StartUnitTransformCommand struct {
 	BaseCommand
+	unitId      int32
+	protoUnitId int32
 }
 
 func (cmd StartUnitTransformCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	// The startUnitTransform command is 12 bytes in length, consisting of 3 int32s. The 1st int32 is the id of the
+	// unit starting to transform and the 2nd int32 the protoUnitId it transforms into. The 3rd int32 is unknown.
+	layout := currentLayout(53)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
 	// debateable, selecting a lot of units and doing this creates one command per unit transformed
 	baseCommand.affectsEAPM = false
-	return StartUnitTransformCommand{*baseCommand}
+	return StartUnitTransformCommand{
+		BaseCommand: *baseCommand,
+		unitId:      readInt32(data, baseCommand.offset+layout.offsetOf("unitId")),
+		protoUnitId: readInt32(data, baseCommand.offset+layout.offsetOf("protoUnitId")),
+	}
+}
+
+func (cmd StartUnitTransformCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return formatTransform(cmd.BaseCommand, cmd.unitId, cmd.protoUnitId, false, input), true
 }
 
 // ========================================================================
@@ -909,12 +1334,7 @@ type UnknownCommand55 struct {
 }
 
 func (cmd UnknownCommand55) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackVector}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(55).ByteLength)
 	return UnknownCommand55{*baseCommand}
 }
 
@@ -929,10 +1349,9 @@ type AutoqueueCommand struct {
 
 func (cmd AutoqueueCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// The autoqueue command is 12 bytes in length, consisting of 3 int32s. The last int32 is the protoUnitId.
-	// inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32}
-	byteLength := 12
-	enrichBaseCommand(baseCommand, byteLength)
-	protoUnitId := readInt32(data, baseCommand.offset+8)
+	layout := currentLayout(66)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	protoUnitId := readInt32(data, baseCommand.offset+layout.offsetOf("protoUnitId"))
 	return AutoqueueCommand{
 		BaseCommand: *baseCommand,
 		protoUnitId: protoUnitId,
@@ -955,16 +1374,45 @@ func (cmd AutoqueueCommand) Format(input FormatterInput) (ReplayGameCommand, boo
 
 type ToggleAutoUnitAbilityCommand struct {
 	BaseCommand
+	abilityId int32
+	enabled   bool
 }
 
 func (cmd ToggleAutoUnitAbilityCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt8}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The toggleAutoUnitAbility command is 9 bytes in length, consisting of 2 int32s and 1 int8. The source units are
+	// the units whose ability is toggled, the 1st int32 is the index of the ability on the unit and the int8 is 1 when
+	// auto casting is turned on and 0 when it is turned off. The 2nd int32 is unknown.
+	layout := currentLayout(67)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	return ToggleAutoUnitAbilityCommand{
+		BaseCommand: *baseCommand,
+		abilityId:   readInt32(data, baseCommand.offset+layout.offsetOf("abilityId")),
+		enabled:     (*data)[baseCommand.offset+layout.offsetOf("enabled")] != 0,
 	}
-	enrichBaseCommand(baseCommand, byteLength)
-	return ToggleAutoUnitAbilityCommand{*baseCommand}
+}
+
+type ToggleAutoAbilityPayload struct {
+	Unit      string
+	AbilityId int
+	Enabled   bool
+}
+
+func (cmd ToggleAutoUnitAbilityCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	// Abilities are toggled for every selected unit of a type at once, so the first source unit names the unit type
+	unit := "unknown"
+	if sourceUnits := cmd.SourceUnits(); len(sourceUnits) > 0 {
+		unit = input.units.protoOf(cmd.PlayerId(), sourceUnits[0], cmd.GameTimeSecs())
+	}
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "toggleAutoAbility",
+		Payload: ToggleAutoAbilityPayload{
+			Unit:      unit,
+			AbilityId: int(cmd.abilityId),
+			Enabled:   cmd.enabled,
+		},
+	}, true
 }
 
 // ========================================================================
@@ -979,8 +1427,7 @@ type TimeShiftCommand struct {
 func (cmd TimeShiftCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// The timeshift command is 32 bytes in length consisting of 2 int32s and 2 vectors. However, none of these bytes
 	// correspond to the command, instead the location of the timeshift is stored in the sourceVectors
-	byteLength := 32
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(68).ByteLength)
 	location := (*baseCommand.sourceVectors)[0]
 	return TimeShiftCommand{
 		BaseCommand: *baseCommand,
@@ -1003,18 +1450,46 @@ func (cmd TimeShiftCommand) Format(input FormatterInput) (ReplayGameCommand, boo
 
 type BuildWallConnectorCommand struct {
 	BaseCommand
+	protoBuildingId int32
+	start           Vector3
+	end             Vector3
 }
 
 func (cmd BuildWallConnectorCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32, unpackVector, unpackVector}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	// The buildWallConnector command is 36 bytes in length, consisting of 3 int32s and 2 vectors. The 1st int32 is the
+	// protoBuildingId of the wall, the vectors are the start and end points of the wall segment. The 2nd and 3rd
+	// int32s are unknown.
+	layout := currentLayout(69)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
 	// Making a simple wall puts out a LOT of these.
 	baseCommand.affectsEAPM = false
-	return BuildWallConnectorCommand{*baseCommand}
+	return BuildWallConnectorCommand{
+		BaseCommand:     *baseCommand,
+		protoBuildingId: readInt32(data, baseCommand.offset+layout.offsetOf("protoBuildingId")),
+		start:           readVector(data, baseCommand.offset+layout.offsetOf("start")),
+		end:             readVector(data, baseCommand.offset+layout.offsetOf("end")),
+	}
+}
+
+type BuildWallPayload struct {
+	Name   string
+	Start  Vector3
+	End    Vector3
+	Length float64
+}
+
+func (cmd BuildWallConnectorCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "buildWall",
+		Payload: BuildWallPayload{
+			Name:   protoName(input.protoRootNode, cmd.protoBuildingId),
+			Start:  cmd.start,
+			End:    cmd.end,
+			Length: groundDistance(cmd.start, cmd.end),
+		},
+	}, true
 }
 
 // ========================================================================
@@ -1023,16 +1498,36 @@ func (cmd BuildWallConnectorCommand) Refine(baseCommand *BaseCommand, data *[]by
 
 type SeekShelterCommand struct {
 	BaseCommand
+	buildingId int32
 }
 
 func (cmd SeekShelterCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	// The seekShelter command is 12 bytes in length, consisting of 3 int32s. The source units are the units sent to
+	// garrison and the 3rd int32 is the building they garrison in, -1 when each unit picks the nearest one. The first
+	// two int32s are unknown.
+	layout := currentLayout(71)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	return SeekShelterCommand{
+		BaseCommand: *baseCommand,
+		buildingId:  readInt32(data, baseCommand.offset+layout.offsetOf("buildingId")),
 	}
-	enrichBaseCommand(baseCommand, byteLength)
-	return SeekShelterCommand{*baseCommand}
+}
+
+type SeekShelterPayload struct {
+	BuildingId int
+	NumUnits   int
+}
+
+func (cmd SeekShelterCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "seekShelter",
+		Payload: SeekShelterPayload{
+			BuildingId: int(cmd.buildingId),
+			NumUnits:   len(cmd.SourceUnits()),
+		},
+	}, true
 }
 
 // ========================================================================
@@ -1053,9 +1548,9 @@ func (cmd PrequeueTechCommand) Refine(baseCommand *BaseCommand, data *[]byte) Ra
 	//   2026-05-02: build 601511 changed the size from 13 to 16 (the trailing 1-byte
 	//               flag became a 4-byte field). Replays from older builds misalign
 	//               here — use a matching older release of this parser to read them.
-	byteLength := 16
-	enrichBaseCommand(baseCommand, byteLength)
-	techId := readInt32(data, baseCommand.offset+8)
+	layout := currentLayout(72)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	techId := readInt32(data, baseCommand.offset+layout.offsetOf("techId"))
 	return PrequeueTechCommand{
 		BaseCommand: *baseCommand,
 		techId:      techId,
@@ -1067,7 +1562,7 @@ func (cmd PrequeueTechCommand) Format(input FormatterInput) (ReplayGameCommand,
 		GameTimeSecs: cmd.GameTimeSecs(),
 		PlayerNum:    cmd.PlayerId(),
 		CommandType:  "prequeueTech",
-		Payload:      input.techTreeRootNode.children[cmd.techId].attributes["name"],
+		Payload:      techName(input.techTreeRootNode, cmd.techId),
 	}, true
 }
 
@@ -1080,12 +1575,7 @@ type UnknownCommand73 struct {
 }
 
 func (cmd UnknownCommand73) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
-	}
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, currentLayout(73).ByteLength)
 	return UnknownCommand73{*baseCommand}
 }
 
@@ -1095,13 +1585,26 @@ func (cmd UnknownCommand73) Refine(baseCommand *BaseCommand, data *[]byte) RawGa
 
 type PrebuyGodPowerCommand struct {
 	BaseCommand
+	protoPowerId int32
 }
 
 func (cmd PrebuyGodPowerCommand) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
 	// The prebuyGodPower command is 16 bytes in length, consisting of 4 int32s. The 3rd xint32 is the protoPowerId.
-	byteLength := 16
-	enrichBaseCommand(baseCommand, byteLength)
-	return PrebuyGodPowerCommand{*baseCommand}
+	layout := currentLayout(75)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
+	return PrebuyGodPowerCommand{
+		BaseCommand:  *baseCommand,
+		protoPowerId: readInt32(data, baseCommand.offset+layout.offsetOf("protoPowerId")),
+	}
+}
+
+func (cmd PrebuyGodPowerCommand) Format(input FormatterInput) (ReplayGameCommand, bool) {
+	return ReplayGameCommand{
+		GameTimeSecs: cmd.GameTimeSecs(),
+		PlayerNum:    cmd.PlayerId(),
+		CommandType:  "prebuyGodPower",
+		Payload:      protoName(input.powersRootNode, cmd.protoPowerId),
+	}, true
 }
 
 // ========================================================================
@@ -1113,17 +1616,10 @@ type UnknownCommand78 struct {
 }
 
 func (cmd UnknownCommand78) Refine(baseCommand *BaseCommand, data *[]byte) RawGameCommand {
-	inputTypes := []func() int{unpackInt32, unpackInt32, unpackInt32, unpackInt32}
-	unknown := readInt32(data, baseCommand.offset+12)
-	if unknown == 3 {
-		inputTypes = append(inputTypes, unpackVector)
-	} else {
-		inputTypes = append(inputTypes, unpackInt32)
-	}
-	byteLength := 0
-	for _, f := range inputTypes {
-		byteLength += f()
+	layout := currentLayout(78)
+	if readInt32(data, baseCommand.offset+layout.offsetOf("probe")) == 3 {
+		layout = currentLayoutVariant(78, "vector")
 	}
-	enrichBaseCommand(baseCommand, byteLength)
+	enrichBaseCommand(baseCommand, layout.ByteLength)
 	return UnknownCommand78{*baseCommand}
 }
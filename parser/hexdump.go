@@ -0,0 +1,184 @@
+package parser
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The annotated hexdump parses the command stream with an annotator, which parseCommandList and parseGameCommand label
+// every byte range they read with, so the labels are exactly what the parser thinks the bytes are. Ranges the parser
+// steps over without looking at them (padding, unknown preamble bytes, body fields the layout calls unknown) are marked
+// with "!", those are the first place to look when a patch shifts a layout. If the stream stops parsing, everything up
+// to the failure is printed followed by the error and the raw bytes after it.
+
+// hexdumpBytesPerLine is the number of bytes printed per line
+const hexdumpBytesPerLine = 16
+
+// hexdumpTrailingBytes is how many raw bytes are printed after the point an annotated dump failed
+const hexdumpTrailingBytes = 128
+
+type byteSpan struct {
+	start         int
+	end           int
+	label         string
+	uninterpreted bool
+	// section spans are zero length and only print their label, they separate command lists and commands
+	section bool
+}
+
+// annotator collects the spans the parser labels while it parses. A nil annotator ignores them, which is how the
+// parser runs outside of the hexdump.
+type annotator struct {
+	spans []byteSpan
+}
+
+func (a *annotator) add(start int, length int, uninterpreted bool, format string, args ...interface{}) {
+	if a == nil || length <= 0 {
+		return
+	}
+	a.spans = append(a.spans, byteSpan{
+		start:         start,
+		end:           start + length,
+		label:         fmt.Sprintf(format, args...),
+		uninterpreted: uninterpreted,
+	})
+}
+
+// labelledUntil returns the end of the last labelled byte range, or fallback if nothing has been labelled
+func (a *annotator) labelledUntil(fallback int) int {
+	for i := len(a.spans) - 1; i >= 0; i-- {
+		if !a.spans[i].section {
+			return a.spans[i].end
+		}
+	}
+	return fallback
+}
+
+func (a *annotator) section(format string, args ...interface{}) {
+	if a == nil {
+		return
+	}
+	a.spans = append(a.spans, byteSpan{label: fmt.Sprintf(format, args...), section: true})
+}
+
+// body labels a command body with the fields of the command's layout, fields the refiner doesn't interpret are marked
+// as such. Variants are told apart by their byte length.
+func (a *annotator) body(commandType int, offset int, byteLength int) {
+	if a == nil {
+		return
+	}
+	for _, layout := range currentLayouts {
+		if layout.CommandType != commandType || layout.ByteLength != byteLength {
+			continue
+		}
+		for _, field := range layout.Fields {
+			a.add(offset+field.Offset, field.Size, !field.Interpreted, "%s (%s)", field.Name, field.Type)
+		}
+		return
+	}
+	a.add(offset, byteLength, true, "body")
+}
+
+// HexdumpToText dumps the command section of a replay, or the l33t decompressed inner buffer when inner is set. With
+// annotate the command section is labelled, see the top of this file. maxTicks limits an annotated dump to the first
+// maxTicks command lists, 0 dumps all of them.
+func HexdumpToText(replayPath string, inner bool, annotate bool, maxTicks int, isGzip bool) (string, error) {
+	rawData, err := readRawData(replayPath, isGzip)
+	if err != nil {
+		return "", err
+	}
+
+	if inner {
+		if annotate {
+			return "", fmt.Errorf("annotations are only available for the command section")
+		}
+		data, err := Decompressl33t(&rawData)
+		if err != nil {
+			return "", err
+		}
+		return formatHexLines(&data, 0, len(data), ""), nil
+	}
+
+	commandOffset, commandCount := locateCommandStream(&rawData)
+	offset, err := firstCommandListOffset(&rawData, commandOffset)
+	if err != nil {
+		return "", err
+	}
+	if !annotate {
+		return formatHexLines(&rawData, offset, len(rawData), ""), nil
+	}
+
+	if maxTicks > 0 && maxTicks < commandCount {
+		commandCount = maxTicks
+	}
+	a := annotator{spans: make([]byteSpan, 0)}
+	endOffset, annotateErr := annotateCommandStream(&rawData, &a, offset, commandCount)
+
+	var sb strings.Builder
+	for _, span := range a.spans {
+		if span.section {
+			sb.WriteString(fmt.Sprintf("# %s\n", span.label))
+			continue
+		}
+		marker := "  "
+		if span.uninterpreted {
+			marker = "! "
+		}
+		sb.WriteString(formatHexLines(&rawData, span.start, span.end, marker+span.label))
+	}
+	if annotateErr != nil {
+		sb.WriteString(fmt.Sprintf("# parse failed: %v\n", annotateErr))
+		sb.WriteString(formatHexLines(&rawData, endOffset, endOffset+hexdumpTrailingBytes, "! unparsed"))
+	}
+	return sb.String(), nil
+}
+
+// formatHexLines prints data[start:end] as hex, 16 bytes per line, with the label on the first line
+func formatHexLines(data *[]byte, start int, end int, label string) string {
+	if end > len(*data) {
+		end = len(*data)
+	}
+	var sb strings.Builder
+	for lineStart := start; lineStart < end; lineStart += hexdumpBytesPerLine {
+		lineEnd := lineStart + hexdumpBytesPerLine
+		if lineEnd > end {
+			lineEnd = end
+		}
+		hexBytes := make([]string, 0, hexdumpBytesPerLine)
+		for _, b := range (*data)[lineStart:lineEnd] {
+			hexBytes = append(hexBytes, fmt.Sprintf("%02x", b))
+		}
+		line := fmt.Sprintf("%08x  %-47s", lineStart, strings.Join(hexBytes, " "))
+		if lineStart == start && label != "" {
+			line += "  " + label
+		}
+		sb.WriteString(strings.TrimRight(line, " ") + "\n")
+	}
+	return sb.String()
+}
+
+// annotateCommandStream parses commandCount command lists starting at offset, labelling them in a. It returns the offset
+// it got to, which is the end of the last labelled byte range when an error is returned. A misaligned stream can make
+// the parser read past the end of the data, which is reported as a failure rather than a panic, as in
+// walkCommandStream.
+func annotateCommandStream(data *[]byte, a *annotator, offset int, commandCount int) (endOffset int, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			endOffset = a.labelledUntil(offset)
+			err = fmt.Errorf("read past the end of the command stream: %v", r)
+		}
+	}()
+
+	for i := 1; i <= commandCount; i++ {
+		a.section("command list %d (game time %s)", i, formatGameTime(float64(i)/20.0))
+		item, err := parseCommandList(data, offset, i, nil, a)
+		if err != nil {
+			return a.labelledUntil(offset), err
+		}
+		if item.entryIdx != i {
+			return item.offsetEnd, fmt.Errorf("entryIdx was not sequential, entryIdx=%v, lastIndex=%v", item.entryIdx, i)
+		}
+		offset = item.offsetEnd
+	}
+	return offset, nil
+}
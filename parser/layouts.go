@@ -0,0 +1,272 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// The command layout table is the single source of truth for the byte layout of every command body. The refiners in
+// gameCommands.go take their byte length and field offsets from it, and `restoration layouts --json` exports it so
+// that external tools (e.g., tools/trace_command_stream.py) read the same spec instead of keeping their own copy.
+//
+// Each layout records the builds it applies to. The parser only ever reads the current build (see "Patch
+// compatibility" in the README), so the refiners use the layouts without a MaxBuild. Older layouts are kept for the
+// export, so that tools looking at old replays know what changed. Every current layout starts at checkedBuild, the
+// build of the replays it was checked against. That is the earliest build known to parse with it, not necessarily the
+// first one it applies to.
+
+type FieldType string
+
+const (
+	Int8Field   FieldType = "int8"
+	Int32Field  FieldType = "int32"
+	FloatField  FieldType = "float"
+	VectorField FieldType = "vector"
+)
+
+var fieldTypeSizes = map[FieldType]int{
+	Int8Field:   1,
+	Int32Field:  4,
+	FloatField:  4,
+	VectorField: 12,
+}
+
+// checkedBuild is the build the replays the layouts were checked against were recorded with
+const checkedBuild = 601511
+
+// unknownField is the name of fields that are read (they count towards the byte length) but not interpreted
+const unknownField = "unknown"
+
+// CommandField is one field of a command body. Offset is relative to the start of the body.
+type CommandField struct {
+	Name        string
+	Type        FieldType
+	Offset      int
+	Size        int
+	Interpreted bool
+}
+
+// CommandLayout is the body layout of a command type for a range of builds. MaxBuild 0 means the layout applies to
+// the current build and MinBuild 0 that the first build it applies to wasn't recorded (see the top of this file).
+// Variant distinguishes layouts of the same command type whose length depends on the body itself, Condition describes
+// when that variant applies.
+type CommandLayout struct {
+	CommandType int
+	Name        string
+	Variant     string
+	Condition   string
+	MinBuild    int
+	MaxBuild    int
+	ByteLength  int
+	Fields      []CommandField
+}
+
+func i8(name string) CommandField {
+	return CommandField{Name: name, Type: Int8Field}
+}
+
+func i32(name string) CommandField {
+	return CommandField{Name: name, Type: Int32Field}
+}
+
+func f32(name string) CommandField {
+	return CommandField{Name: name, Type: FloatField}
+}
+
+func vec(name string) CommandField {
+	return CommandField{Name: name, Type: VectorField}
+}
+
+// newLayout lays the fields out back to back and sums their sizes into the byte length. The layout applies from
+// checkedBuild on unless builds says otherwise.
+func newLayout(commandType int, name string, fields ...CommandField) CommandLayout {
+	layout := CommandLayout{
+		CommandType: commandType,
+		Name:        name,
+		MinBuild:    checkedBuild,
+		Fields:      make([]CommandField, 0, len(fields)),
+	}
+	for _, field := range fields {
+		field.Size = fieldTypeSizes[field.Type]
+		field.Interpreted = field.Name != unknownField
+		field.Offset = layout.ByteLength
+		layout.ByteLength += field.Size
+		layout.Fields = append(layout.Fields, field)
+	}
+	return layout
+}
+
+func (layout CommandLayout) builds(minBuild int, maxBuild int) CommandLayout {
+	layout.MinBuild = minBuild
+	layout.MaxBuild = maxBuild
+	return layout
+}
+
+func (layout CommandLayout) variant(variant string, condition string) CommandLayout {
+	layout.Variant = variant
+	layout.Condition = condition
+	return layout
+}
+
+const u = unknownField
+
+var commandLayouts = []CommandLayout{
+	newLayout(0, "task", i32(u), i32(u), i32("targetUnitId"), i32(u), vec("targetLocation"), f32("targetRange"),
+		i32(u), i32(u), i32(u)),
+	newLayout(1, "research", i32(u), i32(u), i32("techId")),
+	newLayout(2, "train", i32(u), i32(u), i32("protoUnitId"), i32(u), i8(u), i8("numUnits")),
+	newLayout(3, "build", i32(u), i32(u), i32("protoBuildingId"), vec("location"), i32(u), i32(u), f32(u),
+		i32(u), i32(u), i32(u), i32(u)),
+	newLayout(4, "setGatherPoint", i32(u), i32("targetUnitId"), vec("location"), f32("targetRange"), i32(u), i32(u)),
+	newLayout(7, "delete", i32("unitId"), i32(u), i8(u)),
+	newLayout(9, "stop", i32(u), i32(u)),
+	newLayout(12, "useProtoPower", i32(u), i32(u), i32(u), vec("location1"), vec("location2"), i32(u), i32(u),
+		f32(u), i32(u), i32("protoPowerId"), i8(u)),
+	newLayout(13, "marketBuySellResources", i32(u), i32(u), i32("resourceType"), i32(u), f32("quantity")),
+	newLayout(14, "ungarrison", i32("unitId"), i32(u)),
+	newLayout(16, "resign", i32(u), i32(u), i32(u), i32(u), i32(u), i8(u)),
+	newLayout(18, "unknown18", i32(u), i32(u), i32(u)),
+	newLayout(19, "tribute", i32(u), i32(u), i32("recipient"), i32("resourceType"), f32("amount"), f32("tax"), i8(u)),
+	newLayout(23, "finishUnitTransform", i32(u), i32("protoUnitId"), i32("unitId"), i32(u), i8(u), i8(u)),
+	newLayout(25, "setUnitStance", i32(u), i32(u), i8("stance"), i8(u), i32(u)),
+	newLayout(26, "changeDiplomacy", i32(u), i32(u), i8("stance"), i32("targetPlayer")),
+	newLayout(34, "townBell", i32(u), i32(u)),
+	newLayout(35, "autoScoutEvent", i32(u), i32(u), i32(u)),
+	newLayout(37, "changeControlGroupContents", i32("group"), i32("unitId"), i8("operation"), i32(u)),
+	newLayout(38, "repair", i32(u), i32(u), i32(u)),
+	newLayout(39, "unknown39", i32(u), i32(u), i32(u)),
+	newLayout(41, "taunt", i32(u), i32(u), i32("tauntId"), i32(u), i32(u), i32(u), i32(u), i32(u), i32(u), i32(u),
+		i32(u), i8(u)),
+	newLayout(44, "cheat", i32(u), i32(u), i32("cheatId"), i32(u)),
+	newLayout(45, "cancelQueuedItem", i32(u), i32(u), i32("buildingId"), i32("itemId"), i32("queueSlot")),
+	newLayout(48, "setFormation", i32(u), i32(u), i32("formationId"), i32(u)),
+	newLayout(53, "startUnitTransform", i32("unitId"), i32("protoUnitId"), i32(u)),
+	newLayout(55, "unknown55", i32(u), i32(u), vec(u)),
+	newLayout(66, "autoqueue", i32(u), i32(u), i32("protoUnitId")),
+	newLayout(67, "toggleAutoUnitAbility", i32("abilityId"), i32(u), i8("enabled")),
+	newLayout(68, "timeShift", i32(u), i32(u), vec(u), vec(u)),
+	newLayout(69, "buildWallConnector", i32("protoBuildingId"), i32(u), i32(u), vec("start"), vec("end")),
+	newLayout(71, "seekShelter", i32(u), i32(u), i32("buildingId")),
+	// Build 601511 turned the trailing 1-byte flag into a 4-byte field
+	newLayout(72, "prequeueTech", i32(u), i32(u), i32("techId"), i8(u)).builds(0, 601510),
+	newLayout(72, "prequeueTech", i32(u), i32(u), i32("techId"), i32(u)).builds(checkedBuild, 0),
+	newLayout(73, "unknown73", i32(u), i32(u)),
+	newLayout(75, "prebuyGodPower", i32(u), i32(u), i32("protoPowerId"), i32(u)),
+	newLayout(78, "unknown78", i32(u), i32(u), i32(u), i32("probe"), i32(u)),
+	newLayout(78, "unknown78", i32(u), i32(u), i32(u), i32("probe"), vec(u)).
+		variant("vector", "probe == 3"),
+}
+
+type layoutKey struct {
+	commandType int
+	variant     string
+}
+
+// currentLayouts indexes the layouts of the current build by command type and variant
+var currentLayouts = indexCurrentLayouts()
+
+func indexCurrentLayouts() map[layoutKey]CommandLayout {
+	layouts := make(map[layoutKey]CommandLayout)
+	for _, layout := range commandLayouts {
+		if layout.MaxBuild != 0 {
+			continue
+		}
+		key := layoutKey{layout.CommandType, layout.Variant}
+		if _, exists := layouts[key]; exists {
+			slog.Warn("Command layout defined twice for the current build", "type", layout.CommandType)
+		}
+		layouts[key] = layout
+	}
+	return layouts
+}
+
+// currentLayout returns the layout of a command type in the current build. A refiner asking for a layout that doesn't
+// exist is a bug in the table, so this panics rather than misreading the stream.
+func currentLayout(commandType int) CommandLayout {
+	return currentLayoutVariant(commandType, "")
+}
+
+func currentLayoutVariant(commandType int, variant string) CommandLayout {
+	layout, exists := currentLayouts[layoutKey{commandType, variant}]
+	if !exists {
+		panic(fmt.Sprintf("no command layout for type %d variant %q", commandType, variant))
+	}
+	return layout
+}
+
+// CurrentLayouts returns the layouts of a command type in the current build, the default layout first followed by its
+// variants. It is empty for command types the parser has no refiner for.
+func CurrentLayouts(commandType int) []CommandLayout {
+	layouts := make([]CommandLayout, 0)
+	for key, layout := range currentLayouts {
+		if key.commandType == commandType {
+			layouts = append(layouts, layout)
+		}
+	}
+	sort.Slice(layouts, func(i, j int) bool {
+		return layouts[i].Variant < layouts[j].Variant
+	})
+	return layouts
+}
+
+// offsetOf returns the offset of a named field in the body, panicking on names that aren't in the layout for the same
+// reason as currentLayout
+func (layout CommandLayout) offsetOf(name string) int {
+	for _, field := range layout.Fields {
+		if field.Name == name {
+			return field.Offset
+		}
+	}
+	panic(fmt.Sprintf("command layout %s has no field %s", layout.Name, name))
+}
+
+// commandName returns the best-known name of a command type, e.g., "task" for 0
+func commandName(commandType int) string {
+	if layout, exists := currentLayouts[layoutKey{commandType, ""}]; exists {
+		return layout.Name
+	}
+	return fmt.Sprintf("unregistered%d", commandType)
+}
+
+func LayoutsToJson(prettyPrint bool) (string, error) {
+	var jsonBytes []byte
+	var err error
+	if prettyPrint {
+		jsonBytes, err = json.MarshalIndent(commandLayouts, "", "    ")
+	} else {
+		jsonBytes, err = json.Marshal(commandLayouts)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+func LayoutsToText() string {
+	layouts := append([]CommandLayout{}, commandLayouts...)
+	sort.SliceStable(layouts, func(i, j int) bool {
+		return layouts[i].CommandType < layouts[j].CommandType
+	})
+
+	var sb strings.Builder
+	for _, layout := range layouts {
+		builds := fmt.Sprintf("%d+", layout.MinBuild)
+		if layout.MaxBuild != 0 {
+			builds = fmt.Sprintf("%d-%d", layout.MinBuild, layout.MaxBuild)
+		}
+		name := layout.Name
+		if layout.Variant != "" {
+			name = fmt.Sprintf("%s (%s, when %s)", name, layout.Variant, layout.Condition)
+		}
+		sb.WriteString(fmt.Sprintf("%d %s, %d bytes, builds %s\n", layout.CommandType, name, layout.ByteLength, builds))
+		for _, field := range layout.Fields {
+			sb.WriteString(fmt.Sprintf("  +%-3d %-7s %s\n", field.Offset, field.Type, field.Name))
+		}
+	}
+	return sb.String()
+}
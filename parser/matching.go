@@ -0,0 +1,76 @@
+package parser
+
+import (
+	"os"
+	"path"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Opening rules (openings.go) and check rules (rules.go) both count what a player did in a window of game time and
+// compare the count against bounds. The predicates they share live here, as does reading their YAML files.
+
+// TimeWindow accepts game times between AfterSecs and BeforeSecs, a BeforeSecs of 0 means no upper bound.
+type TimeWindow struct {
+	AfterSecs  float64 `yaml:"afterSecs"`
+	BeforeSecs float64 `yaml:"beforeSecs"`
+}
+
+func (window TimeWindow) includes(gameTimeSecs float64) bool {
+	if gameTimeSecs < window.AfterSecs {
+		return false
+	}
+	return window.BeforeSecs <= 0 || gameTimeSecs <= window.BeforeSecs
+}
+
+// CountRange bounds a count to between MinCount and MaxCount. MinCount defaults to 1 when neither is set, MaxCount 0
+// expresses "never did X".
+type CountRange struct {
+	MinCount int  `yaml:"minCount"`
+	MaxCount *int `yaml:"maxCount"`
+}
+
+func (countRange CountRange) allows(count int) bool {
+	minCount := countRange.MinCount
+	if minCount == 0 && countRange.MaxCount == nil {
+		minCount = 1
+	}
+	if count < minCount {
+		return false
+	}
+	return countRange.MaxCount == nil || count <= *countRange.MaxCount
+}
+
+// globMatches matches name against a glob pattern, an empty pattern matches everything. A pattern can list
+// alternatives separated by "|" (e.g., "research|prequeueTech"), name has to match one of them. Patterns are validated
+// with validatePattern when the rules are loaded so the error is ignored here.
+func globMatches(pattern string, name string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, alternative := range strings.Split(pattern, "|") {
+		if matched, _ := path.Match(alternative, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func validatePattern(pattern string) error {
+	for _, alternative := range strings.Split(pattern, "|") {
+		if _, err := path.Match(alternative, ""); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadRulesFile reads a YAML rules file into rules. JSON is a subset of YAML, so rules files written as JSON load too.
+func loadRulesFile(rulesPath string, rules interface{}) error {
+	rulesBytes, err := os.ReadFile(rulesPath)
+	if err != nil {
+		return err
+	}
+	return yaml.Unmarshal(rulesBytes, rules)
+}
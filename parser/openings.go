@@ -0,0 +1,121 @@
+package parser
+
+import (
+	"fmt"
+	"log/slog"
+)
+
+// Openings are labelled with a user-editable rules file rather than hard coded heuristics, the meta shifts with every
+// patch and the community disagrees on what e.g. a "fast Heroic" is. See rules/openings.yaml for an example:
+//
+//	openings:
+//	  - name: Fast Heroic
+//	    conditions:
+//	      - {action: ageUp, name: Heroic, beforeSecs: 510}
+//
+// Rules are evaluated in order and the first rule whose conditions all match a player's build order becomes that
+// player's Opening. A rule with no conditions always matches and can be used as a fallback at the end of the list.
+
+type OpeningRules struct {
+	Openings []OpeningRule `yaml:"openings"`
+}
+
+type OpeningRule struct {
+	Name       string             `yaml:"name"`
+	Conditions []OpeningCondition `yaml:"conditions"`
+}
+
+// OpeningCondition matches build order steps. Action is a build order action (train, build, research, prequeueTech,
+// ageUp, godPower) and Name is a glob matched against the unit, building, tech or god power name. For ageUp, Name is
+// matched against both the age (e.g., "Heroic") and the age-up tech (e.g., "HeroicAgeApollo"). Only steps clicked in
+// the TimeWindow are counted and the summed count of matching steps must be in the CountRange.
+type OpeningCondition struct {
+	Action     string `yaml:"action"`
+	Name       string `yaml:"name"`
+	TimeWindow `yaml:",inline"`
+	CountRange `yaml:",inline"`
+}
+
+var openingActions = map[string]struct{}{
+	"train":        {},
+	"build":        {},
+	"research":     {},
+	"prequeueTech": {},
+	"ageUp":        {},
+	"godPower":     {},
+}
+
+func LoadOpeningRules(rulesPath string) (*OpeningRules, error) {
+	var rules OpeningRules
+	if err := loadRulesFile(rulesPath, &rules); err != nil {
+		return nil, fmt.Errorf("error reading opening rules %s: %w", rulesPath, err)
+	}
+
+	for i, rule := range rules.Openings {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("opening rule %d has no name", i)
+		}
+		for _, condition := range rule.Conditions {
+			if _, exists := openingActions[condition.Action]; !exists {
+				return nil, fmt.Errorf("opening rule %s has unknown action %q", rule.Name, condition.Action)
+			}
+			if err := validatePattern(condition.Name); err != nil {
+				return nil, fmt.Errorf("opening rule %s has a bad name pattern %q: %w", rule.Name, condition.Name, err)
+			}
+		}
+	}
+	slog.Debug("Loaded opening rules", "path", rulesPath, "numRules", len(rules.Openings))
+	return &rules, nil
+}
+
+func addOpeningsToPlayers(
+	players *[]ReplayPlayer,
+	commandList *[]RawGameCommand,
+	formatterInput FormatterInput,
+	gameLengthSecs float64,
+	rules *OpeningRules,
+) {
+	slog.Debug("Adding openings to players")
+	buildOrders := getBuildOrders(players, commandList, formatterInput, gameLengthSecs)
+	for i := range *players {
+		(*players)[i].Opening = classifyOpening(&buildOrders[i], rules)
+	}
+}
+
+func classifyOpening(buildOrder *PlayerBuildOrder, rules *OpeningRules) string {
+	for _, rule := range rules.Openings {
+		matches := true
+		for _, condition := range rule.Conditions {
+			if !conditionMatches(buildOrder, condition) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			slog.Debug("Opening matched", "playerNum", buildOrder.PlayerNum, "opening", rule.Name)
+			return rule.Name
+		}
+	}
+	return ""
+}
+
+func conditionMatches(buildOrder *PlayerBuildOrder, condition OpeningCondition) bool {
+	count := 0
+	if condition.Action == "ageUp" {
+		for _, ageUp := range buildOrder.AgeUps {
+			if condition.includes(ageUp.ClickedAtSecs) &&
+				(globMatches(condition.Name, ageUp.Age) || globMatches(condition.Name, ageUp.Name)) {
+				count++
+			}
+		}
+	} else {
+		for _, step := range buildOrder.Steps {
+			if step.Action == condition.Action &&
+				condition.includes(step.GameTimeSecs) &&
+				globMatches(condition.Name, step.Name) {
+				count += step.Count
+			}
+		}
+	}
+	return condition.allows(count)
+}
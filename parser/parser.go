@@ -9,8 +9,22 @@ import (
 	"os"
 )
 
-func ParseToJson(replayPath string, prettyPrint bool, slim bool, stats bool, isGzip bool) (string, error) {
-	replayFormat, err := Parse(replayPath, slim, stats, isGzip)
+// ParseOptions selects what Parse reads and adds to its output, the zero value parses a plain .mythrec into the
+// default output. Slim leaves out the game commands and Stats adds the per player stats, tribute flows and unit ids.
+// OpeningRules is optional, when nil the players' Opening is left empty. SourceUnits adds the acting unit ids to every
+// game command and the per tick unit selections to the output. RawCommands adds every command as it was read from the
+// stream, including the ones without a formatter.
+type ParseOptions struct {
+	Slim         bool
+	Stats        bool
+	IsGzip       bool
+	OpeningRules *OpeningRules
+	SourceUnits  bool
+	RawCommands  bool
+}
+
+func ParseToJson(replayPath string, prettyPrint bool, options ParseOptions) (string, error) {
+	replayFormat, err := Parse(replayPath, options)
 	if err != nil {
 		return "", err
 	}
@@ -36,23 +50,94 @@ func ParseToJson(replayPath string, prettyPrint bool, slim bool, stats bool, isG
 // pattern or multiple files as input and each file will be parsed in its own go routine.
 // If we do need to add more optimization, all of the recursive functions could easily spin up a go routine to parse its
 // subtree.
-func Parse(replayPath string, slim bool, stats bool, isGzip bool) (ReplayFormatted, error) {
-	raw_data, err := os.ReadFile(replayPath)
+func Parse(replayPath string, options ParseOptions) (ReplayFormatted, error) {
+	replay, err := readReplay(replayPath, options.IsGzip)
+	if err != nil {
+		return ReplayFormatted{}, err
+	}
+	formatterInput, err := buildFormatterInput(&replay.data, &replay.xmbMap)
 	if err != nil {
 		return ReplayFormatted{}, err
 	}
+	replay.commandList = resolveCancelledItems(replay.commandList, formatterInput)
+
+	replayFormat, err := formatRawDataToReplay(
+		options,
+		&replay.data,
+		&replay.rootNode,
+		&replay.profileKeys,
+		&replay.xmbMap,
+		&replay.commandList,
+		&replay.selections,
+		&formatterInput,
+	)
+	if err != nil {
+		return ReplayFormatted{}, err
+	}
+	if options.SourceUnits {
+		replayFormat.Selections = &replay.selections
+	}
+	if options.RawCommands {
+		entries := formatRawCommands(&replay.rawData, &replay.commandList)
+		replayFormat.RawCommands = &entries
+	}
+
+	return replayFormat, nil
+}
+
+// rawReplay holds everything read out of a .mythrec before it is formatted. data is the l33t decompressed buffer the
+// header and XMB files live in, rawData is the outer buffer the command stream is read from.
+type rawReplay struct {
+	data        []byte
+	rawData     []byte
+	rootNode    Node
+	xmbMap      map[string]XmbFile
+	profileKeys map[string]ProfileKey
+	commandList []RawGameCommand
+	selections  []SelectionSnapshot
+}
+
+// readRawData reads the outer buffer of a replay, gunzipping it first if needed
+func readRawData(replayPath string, isGzip bool) ([]byte, error) {
+	raw_data, err := os.ReadFile(replayPath)
+	if err != nil {
+		return nil, err
+	}
 
 	if isGzip {
 		raw_data, err = DecompressGzip(&raw_data)
 
 		if err != nil {
-			return ReplayFormatted{}, err
+			return nil, err
 		}
 	}
+	return raw_data, nil
+}
+
+// locateCommandStream returns the offset the command stream is searched from and the number of command lists in it
+func locateCommandStream(raw_data *[]byte) (int, int) {
+	commandCount := readUint32(raw_data, 23)
+	slog.Debug("commandCount", "commandCount", commandCount)
+
+	svBytes := bytes.Index(*raw_data, []byte{0x73, 0x76}) // search for index of the "sv" bytes
+	commandOffset := readUint32(raw_data, svBytes+2)
+	slog.Debug("commandOffset", "commandOffset", commandOffset)
+	return int(commandOffset), int(commandCount)
+}
+
+func readReplay(replayPath string, isGzip bool) (rawReplay, error) {
+	raw_data, err := readRawData(replayPath, isGzip)
+	if err != nil {
+		return rawReplay{}, err
+	}
+	return decodeReplay(raw_data)
+}
 
+// decodeReplay reads everything out of the outer buffer of a replay, see readReplay
+func decodeReplay(raw_data []byte) (rawReplay, error) {
 	data, err := Decompressl33t(&raw_data)
 	if err != nil {
-		return ReplayFormatted{}, err
+		return rawReplay{}, err
 	}
 	// saveHex(&data, "decompressed.hex")
 
@@ -63,7 +148,7 @@ func Parse(replayPath string, slim bool, stats bool, isGzip bool) (ReplayFormatt
 	// around instead.
 	xmbMap, err := parseXmbMap(&data, rootNode)
 	if err != nil {
-		return ReplayFormatted{}, err
+		return rawReplay{}, err
 	}
 	// for key, _ := range xmbMap {
 	// 	fmt.Println(key)
@@ -71,47 +156,35 @@ func Parse(replayPath string, slim bool, stats bool, isGzip bool) (ReplayFormatt
 
 	profileKeys, err := parseProfileKeys(&data, rootNode)
 	if err != nil {
-		return ReplayFormatted{}, err
+		return rawReplay{}, err
 	}
 	//printProfileKeys(profileKeys)
-	// for key, _ := range xmbMap {
-	// 	fmt.Println("==========================")
-	// 	fmt.Println(key)
-	// }
-	// techtreerootnode, err := parseXmb(&data, xmbMap["protounitcommands"])
-	// if err != nil {
-	// 	return ReplayFormatted{}, err
-	// }
-	// for _, child := range techtreerootnode.children {
-	// 	fmt.Println(child)
-	// }
-
-	commandCount := readUint32(&raw_data, 23)
-	slog.Debug("commandCount", "commandCount", commandCount)
-
-	svBytes := bytes.Index(raw_data, []byte{0x73, 0x76}) // search for index of the "sv" bytes
-	commandOffset := readUint32(&raw_data, svBytes+2)
-	slog.Debug("commandOffset", "commandOffset", commandOffset)
 
-	commandList, err := parseGameCommands(&raw_data, int(commandOffset), int(commandCount))
+	commandOffset, commandCount := locateCommandStream(&raw_data)
+	commandList, selections, err := parseGameCommands(&raw_data, commandOffset, commandCount)
 	if err != nil {
-		return ReplayFormatted{}, err
-	}
-
-	replayFormat, err := formatRawDataToReplay(slim, stats, &data, &rootNode, &profileKeys, &xmbMap, &commandList)
-	if err != nil {
-		return ReplayFormatted{}, err
+		return rawReplay{}, err
 	}
 
-	return replayFormat, nil
+	return rawReplay{
+		data:        data,
+		rawData:     raw_data,
+		rootNode:    rootNode,
+		xmbMap:      xmbMap,
+		profileKeys: profileKeys,
+		commandList: commandList,
+		selections:  selections,
+	}, nil
 }
 
 func isRootNode(node Node) bool {
 	return node.token == ROOT_NODE_TOKEN
 }
 
+// ProfileKey is a key of the MP/ST node. Offset is where the value starts in the decompressed header.
 type ProfileKey struct {
 	Type      string
+	Offset    int
 	EndOffset int
 	StringVal string
 	Uint32Val uint32
@@ -207,6 +280,7 @@ func parseProfileKeys(data *[]byte, node Node) (map[string]ProfileKey, error) {
 		}
 
 		profileKey := parseFunc(data, position, keyname.value)
+		profileKey.Offset = position
 		profileKeys[keyname.value] = profileKey
 		position = profileKey.EndOffset
 	}
@@ -0,0 +1,256 @@
+package parser
+
+import (
+	"log/slog"
+	"math"
+)
+
+// The command stream records what a player clicked, not what they ended up with. Anything sitting in a queue can be
+// cancelled again with a cancelQueuedItem command. This file links cancels back to the click that queued the item
+// they removed and keeps a ledger of gross (everything queued) and net (queued minus cancelled) production.
+//
+// A train click can queue a batch of units (5 for a shift-click), see TrainCommand.batchSize. A cancel removes one
+// unit of a batch, the click stays in the queue until all of its units are cancelled.
+
+// queuedItem is a click that put an item in a building's queue. doneAtSecs is when the last unit of the click is
+// estimated to leave the queue, +Inf when the train or research time isn't in the XMB data.
+type queuedItem struct {
+	kind        QueuedItemKind
+	id          int32
+	sourceUnits *[]uint32
+	commandIdx  int
+	remaining   int
+	doneAtSecs  float64
+}
+
+// inQueueOf reports whether the item could be the item a cancel with that building and id removed at gameTimeSecs
+func (item *queuedItem) inQueueOf(buildingId int32, id int32, gameTimeSecs float64) bool {
+	return item.remaining > 0 &&
+		item.id == id &&
+		gameTimeSecs <= item.doneAtSecs &&
+		queuedInBuilding(item.sourceUnits, buildingId)
+}
+
+// queuedInBuilding reports whether an item queued by a command with the given source units could live in the queue of
+// buildingId. Commands without source units (e.g., prequeues from the age-up panel) match any building.
+func queuedInBuilding(sourceUnits *[]uint32, buildingId int32) bool {
+	if sourceUnits == nil || len(*sourceUnits) == 0 || buildingId < 0 {
+		return true
+	}
+	for _, unitId := range *sourceUnits {
+		if int32(unitId) == buildingId {
+			return true
+		}
+	}
+	return false
+}
+
+// productionQueues estimates the queue of every building a player trains or researches in. Items in a queue are
+// produced one after the other, so an item starts when it was clicked or when the item before it is done, whichever
+// is later. A click with several buildings selected is put in the queue of the first one.
+type productionQueues struct {
+	items    map[int][]*queuedItem
+	queueEnd map[int]map[int32]float64
+}
+
+func (queues *productionQueues) add(playerId int, item *queuedItem, gameTimeSecs float64, unitSecs float64) {
+	building := int32(-1)
+	if item.sourceUnits != nil && len(*item.sourceUnits) > 0 {
+		building = int32((*item.sourceUnits)[0])
+	}
+	if queues.queueEnd[playerId] == nil {
+		queues.queueEnd[playerId] = make(map[int32]float64)
+	}
+	startSecs := math.Max(gameTimeSecs, queues.queueEnd[playerId][building])
+	item.doneAtSecs = math.Inf(1)
+	if unitSecs > 0 {
+		item.doneAtSecs = startSecs + unitSecs*float64(item.remaining)
+	}
+	queues.queueEnd[playerId][building] = item.doneAtSecs
+	queues.items[playerId] = append(queues.items[playerId], item)
+}
+
+// resolveCancelledItems works out what each cancelQueuedItem command removed from a queue and returns the command
+// list with the cancels resolved, commandList itself is left as is. The cancel carries the building and an id that is
+// either a protoUnitId or a techId, but not which of the two, so the item is taken to be the most recent click with
+// that id the same player queued in that building and that is still in the queue. Items leave the queue once they are
+// estimated to be done, from the trainpoints and researchpoints in the XMB data. Cancels that match nothing are left
+// as QueuedUnknown.
+//
+// Buildings are placed by villagers rather than queued in a building, so they are never the item of a cancel.
+func resolveCancelledItems(commandList []RawGameCommand, formatterInput FormatterInput) []RawGameCommand {
+	resolved := make([]RawGameCommand, len(commandList))
+	copy(resolved, commandList)
+
+	queues := productionQueues{
+		items:    make(map[int][]*queuedItem),
+		queueEnd: make(map[int]map[int32]float64),
+	}
+	for i, command := range resolved {
+		playerId := command.PlayerId()
+		switch cmd := command.(type) {
+		case TrainCommand:
+			unitSecs := xmbChildFloat(protoNode(formatterInput.protoRootNode, cmd.protoUnitId), "trainpoints")
+			queues.add(playerId, &queuedItem{
+				kind: QueuedUnit, id: cmd.protoUnitId, sourceUnits: cmd.sourceUnits,
+				commandIdx: i, remaining: cmd.batchSize(),
+			}, cmd.GameTimeSecs(), unitSecs)
+		case ResearchCommand:
+			researchSecs := xmbChildFloat(techNode(formatterInput.techTreeRootNode, cmd.techId), "researchpoints")
+			queues.add(playerId, &queuedItem{
+				kind: QueuedTech, id: cmd.techId, sourceUnits: cmd.sourceUnits, commandIdx: i, remaining: 1,
+			}, cmd.GameTimeSecs(), researchSecs)
+		case PrequeueTechCommand:
+			researchSecs := xmbChildFloat(techNode(formatterInput.techTreeRootNode, cmd.techId), "researchpoints")
+			queues.add(playerId, &queuedItem{
+				kind: QueuedTech, id: cmd.techId, sourceUnits: cmd.sourceUnits, commandIdx: i, remaining: 1,
+			}, cmd.GameTimeSecs(), researchSecs)
+		case CancelQueuedItemCommand:
+			if item := findCancelledItem(queues.items[playerId], cmd); item != nil {
+				item.remaining--
+				cmd.itemKind = item.kind
+				cmd.cancelledCommandIdx = item.commandIdx
+			} else {
+				slog.Debug("Could not resolve cancelled item", "playerId", playerId, "itemId", cmd.itemId)
+			}
+			resolved[i] = cmd
+		}
+	}
+	return resolved
+}
+
+// findCancelledItem returns the most recent queued item a cancel could have removed, or nil when there is none
+func findCancelledItem(queue []*queuedItem, cancel CancelQueuedItemCommand) *queuedItem {
+	var match *queuedItem
+	for j := len(queue) - 1; j >= 0; j-- {
+		item := queue[j]
+		if !item.inQueueOf(cancel.buildingId, cancel.itemId, cancel.GameTimeSecs()) {
+			continue
+		}
+		if match == nil {
+			match = item
+		} else if item.kind != match.kind {
+			// A unit and a tech with the same id are both queued, the most recent click is the better guess
+			slog.Debug("Cancelled item is ambiguous", "itemId", cancel.itemId, "kind", match.kind, "otherKind", item.kind)
+			break
+		}
+	}
+	return match
+}
+
+// productionLedger holds the gross and net totals of what a player queued. Gross counts include every queued unit and
+// tech, and every building placed. Net counts subtract the items that were cancelled afterwards, buildings are never
+// cancelled through a queue so they only have one count. The per minute gross counts are the unit and building
+// timelines, so that they add up to the gross totals.
+type productionLedger struct {
+	grossUnits        map[string]int
+	netUnits          map[string]int
+	buildings         map[string]int
+	grossTechs        []string
+	netTechs          []string
+	cancelledCounts   map[string]int
+	unitsByMinute     map[int]map[string]int
+	buildingsByMinute map[int]map[string]int
+}
+
+func calcProduction(rawPlayerCommandList []RawGameCommand, formatterInput FormatterInput) productionLedger {
+	ledger := productionLedger{
+		grossUnits:        make(map[string]int),
+		netUnits:          make(map[string]int),
+		buildings:         make(map[string]int),
+		grossTechs:        make([]string, 0),
+		netTechs:          make([]string, 0),
+		cancelledCounts:   make(map[string]int),
+		unitsByMinute:     make(map[int]map[string]int),
+		buildingsByMinute: make(map[int]map[string]int),
+	}
+	techCounts := make(map[string]int)
+
+	for _, command := range rawPlayerCommandList {
+		switch cmd := command.(type) {
+		case TrainCommand:
+			name := protoName(formatterInput.protoRootNode, cmd.protoUnitId)
+			ledger.grossUnits[name] += cmd.batchSize()
+			ledger.netUnits[name] += cmd.batchSize()
+			addToMinute(ledger.unitsByMinute, cmd.GameTimeSecs(), name, cmd.batchSize())
+		case BuildCommand:
+			name := protoName(formatterInput.protoRootNode, cmd.protoBuildingId)
+			ledger.buildings[name] += 1
+			addToMinute(ledger.buildingsByMinute, cmd.GameTimeSecs(), name, 1)
+		case ResearchCommand:
+			ledger.grossTechs = addTech(ledger.grossTechs, techCounts, formatterInput.techTreeRootNode, cmd.techId)
+		case PrequeueTechCommand:
+			ledger.grossTechs = addTech(ledger.grossTechs, techCounts, formatterInput.techTreeRootNode, cmd.techId)
+		case CancelQueuedItemCommand:
+			formatted, _ := cmd.Format(formatterInput)
+			name := formatted.Payload.(CancelQueuedItemPayload).Name
+			ledger.cancelledCounts[name] += 1
+			switch cmd.itemKind {
+			case QueuedUnit:
+				ledger.netUnits[name] -= 1
+			case QueuedTech:
+				techCounts[name] -= 1
+			}
+		}
+	}
+
+	// Net techs keep the order techs were first queued in, but drop the ones whose every queue was cancelled
+	for _, tech := range ledger.grossTechs {
+		if techCounts[tech] > 0 {
+			ledger.netTechs = append(ledger.netTechs, tech)
+		}
+	}
+	removeNonPositive(ledger.netUnits)
+	return ledger
+}
+
+// addToMinute adds count to name in the 1-based minute gameTimeSecs falls in, the same bucketing as calcTimelines
+func addToMinute(countsByMinute map[int]map[string]int, gameTimeSecs float64, name string, count int) {
+	minute := int(math.Ceil(gameTimeSecs / 60.0))
+	if countsByMinute[minute] == nil {
+		countsByMinute[minute] = make(map[string]int)
+	}
+	countsByMinute[minute][name] += count
+}
+
+// addProductionToTimelines fills the unit and building timelines from the ledger's per minute gross counts
+func addProductionToTimelines(timelines *Timelines, ledger productionLedger) {
+	fillTimeline(timelines.UnitCounts, ledger.unitsByMinute)
+	fillTimeline(timelines.BuildingCounts, ledger.buildingsByMinute)
+}
+
+func fillTimeline(timeline []map[string]int, countsByMinute map[int]map[string]int) {
+	for minute, counts := range countsByMinute {
+		if minute < 1 || minute > len(timeline) {
+			slog.Debug("Production outside of the timeline", "minute", minute, "numMinutes", len(timeline))
+			continue
+		}
+		if timeline[minute-1] == nil {
+			timeline[minute-1] = make(map[string]int)
+		}
+		for name, count := range counts {
+			timeline[minute-1][name] += count
+		}
+	}
+}
+
+func addTech(techs []string, techCounts map[string]int, techTreeRootNode *XmbNode, techId int32) []string {
+	node := techNode(techTreeRootNode, techId)
+	if node == nil {
+		return techs
+	}
+	name := node.attributes["name"]
+	if _, exists := techCounts[name]; !exists {
+		techs = append(techs, name)
+	}
+	techCounts[name] += 1
+	return techs
+}
+
+func removeNonPositive(counts map[string]int) {
+	for name, count := range counts {
+		if count <= 0 {
+			delete(counts, name)
+		}
+	}
+}
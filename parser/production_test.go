@@ -0,0 +1,155 @@
+package parser_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// Proto and tech ids of testReplay
+const (
+	villager = 0
+	hoplite  = 1
+	house    = 2
+	plow     = 1
+)
+
+func TestProductionAndCancels(t *testing.T) {
+	tests := []struct {
+		name     string
+		commands []encoder.Command
+		// cancelKinds are the resolved kinds of the cancels, in order
+		cancelKinds []string
+		netUnits    map[string]int
+		grossUnits  map[string]int
+		buildings   map[string]int
+	}{
+		{
+			name: "a cancel removes one unit of a batch",
+			commands: []encoder.Command{
+				encoder.Train{CommandHeader: at(1, 20, 100), ProtoUnitId: hoplite, NumUnits: 5},
+				encoder.CancelQueuedItem{CommandHeader: at(1, 40, 100), BuildingId: 100, ItemId: hoplite},
+			},
+			cancelKinds: []string{"unit"},
+			netUnits:    map[string]int{"Hoplite": 4},
+			grossUnits:  map[string]int{"Hoplite": 5},
+		},
+		{
+			name: "finished items can't be cancelled",
+			commands: []encoder.Command{
+				encoder.Train{CommandHeader: at(1, 20, 100), ProtoUnitId: villager},
+				// The villager is done 15 seconds after the click
+				encoder.CancelQueuedItem{CommandHeader: at(1, 20+20*20, 100), BuildingId: 100, ItemId: villager},
+			},
+			cancelKinds: []string{"unknown"},
+			netUnits:    map[string]int{"Villager": 1},
+			grossUnits:  map[string]int{"Villager": 1},
+		},
+		{
+			name: "items wait for the items queued before them",
+			commands: []encoder.Command{
+				encoder.Train{CommandHeader: at(1, 20, 100), ProtoUnitId: hoplite, NumUnits: 5},
+				encoder.Train{CommandHeader: at(1, 40, 100), ProtoUnitId: villager},
+				// 5 Hoplites take 100 seconds, so the villager is still queued after 60
+				encoder.CancelQueuedItem{CommandHeader: at(1, 20+60*20, 100), BuildingId: 100, ItemId: villager},
+			},
+			cancelKinds: []string{"unit"},
+			netUnits:    map[string]int{"Hoplite": 5},
+			grossUnits:  map[string]int{"Hoplite": 5, "Villager": 1},
+		},
+		{
+			name: "cancels only match the building the item was queued in",
+			commands: []encoder.Command{
+				encoder.Train{CommandHeader: at(1, 20, 100), ProtoUnitId: villager},
+				encoder.CancelQueuedItem{CommandHeader: at(1, 40, 101), BuildingId: 101, ItemId: villager},
+			},
+			cancelKinds: []string{"unknown"},
+			netUnits:    map[string]int{"Villager": 1},
+			grossUnits:  map[string]int{"Villager": 1},
+		},
+		{
+			name: "a unit and a tech with the same id resolve to the most recent click",
+			commands: []encoder.Command{
+				encoder.Research{CommandHeader: at(1, 20, 100), TechId: plow},
+				encoder.Train{CommandHeader: at(1, 40, 100), ProtoUnitId: hoplite},
+				encoder.CancelQueuedItem{CommandHeader: at(1, 60, 100), BuildingId: 100, ItemId: hoplite},
+				encoder.CancelQueuedItem{CommandHeader: at(1, 80, 100), BuildingId: 100, ItemId: plow},
+			},
+			cancelKinds: []string{"unit", "tech"},
+			netUnits:    map[string]int{},
+			grossUnits:  map[string]int{"Hoplite": 1},
+		},
+		{
+			name: "buildings are never the item of a cancel",
+			commands: []encoder.Command{
+				encoder.Build{CommandHeader: at(1, 20, 7), ProtoBuildingId: house},
+				encoder.CancelQueuedItem{CommandHeader: at(1, 40, 7), BuildingId: 7, ItemId: house},
+			},
+			cancelKinds: []string{"unknown"},
+			netUnits:    map[string]int{},
+			grossUnits:  map[string]int{},
+			buildings:   map[string]int{"House": 1},
+		},
+		{
+			name: "a cancelled tech isn't researched",
+			commands: []encoder.Command{
+				encoder.Research{CommandHeader: at(1, 20, 100), TechId: plow},
+				encoder.CancelQueuedItem{CommandHeader: at(1, 40, 100), BuildingId: 100, ItemId: plow},
+			},
+			cancelKinds: []string{"tech"},
+			netUnits:    map[string]int{},
+			grossUnits:  map[string]int{},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			replay := testReplay(append(test.commands, encoder.Resign{CommandHeader: at(2, 2000)})...)
+			formatted, err := parser.Parse(writeReplay(t, replay), parser.ParseOptions{Stats: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cancelKinds := make([]string, 0)
+			for _, command := range *formatted.GameCommands {
+				if payload, ok := command.Payload.(parser.CancelQueuedItemPayload); ok {
+					cancelKinds = append(cancelKinds, payload.Kind)
+				}
+			}
+			if !reflect.DeepEqual(cancelKinds, test.cancelKinds) {
+				t.Errorf("cancel kinds = %v, want %v", cancelKinds, test.cancelKinds)
+			}
+
+			stats := (*formatted.Stats)[1]
+			if !reflect.DeepEqual(stats.UnitCounts, test.netUnits) {
+				t.Errorf("UnitCounts = %v, want %v", stats.UnitCounts, test.netUnits)
+			}
+			if !reflect.DeepEqual(stats.GrossUnitCounts, test.grossUnits) {
+				t.Errorf("GrossUnitCounts = %v, want %v", stats.GrossUnitCounts, test.grossUnits)
+			}
+			buildings := test.buildings
+			if buildings == nil {
+				buildings = map[string]int{}
+			}
+			if !reflect.DeepEqual(stats.BuildingCounts, buildings) {
+				t.Errorf("BuildingCounts = %v, want %v", stats.BuildingCounts, buildings)
+			}
+			if len(stats.TechsResearched) != 0 {
+				t.Errorf("TechsResearched = %v, want none", stats.TechsResearched)
+			}
+
+			// The unit timeline adds up to the gross counts
+			timelineUnits := make(map[string]int)
+			for _, counts := range stats.Timelines.UnitCounts {
+				for name, count := range counts {
+					timelineUnits[name] += count
+				}
+			}
+			if !reflect.DeepEqual(timelineUnits, test.grossUnits) {
+				t.Errorf("unit timeline adds up to %v, want %v", timelineUnits, test.grossUnits)
+			}
+		})
+	}
+}
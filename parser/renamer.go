@@ -12,26 +12,8 @@ import (
 func RenameRecFiles(dir string, isGzip bool, prefix string, suffix string) error {
 	slog.Info("Renaming replays in directory", "directory", dir, "isGzip", isGzip)
 
-	// Determine file extension to search for
-	extension := ".mythrec"
-	if isGzip {
-		extension += ".gz"
-	}
-
-	replayFiles := []string{}
-	// Walk through directory
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		// Skip if not a file or doesn't have correct extension
-		if info.IsDir() || !strings.HasSuffix(path, extension) {
-			return nil
-		}
-		replayFiles = append(replayFiles, path)
-		return nil
-	})
+	extension := replayExtension(isGzip)
+	replayFiles, err := findReplayFiles(dir, isGzip)
 	if err != nil {
 		return err
 	}
@@ -48,7 +30,7 @@ func RenameRecFiles(dir string, isGzip bool, prefix string, suffix string) error
 		go func(inputFilepath string) {
 			defer wg.Done()
 
-			replay, err := Parse(inputFilepath, true, false, isGzip)
+			replay, err := Parse(inputFilepath, ParseOptions{Slim: true, IsGzip: isGzip})
 			if err != nil {
 				errChan <- fmt.Errorf("error parsing %s: %w", inputFilepath, err)
 				return
@@ -98,3 +80,31 @@ func RenameRecFiles(dir string, isGzip bool, prefix string, suffix string) error
 
 	return nil
 }
+
+// replayExtension is the file extension of the replays we look for in a directory
+func replayExtension(isGzip bool) string {
+	extension := ".mythrec"
+	if isGzip {
+		extension += ".gz"
+	}
+	return extension
+}
+
+// findReplayFiles walks dir and returns every replay file in it (or in its subdirectories)
+func findReplayFiles(dir string, isGzip bool) ([]string, error) {
+	extension := replayExtension(isGzip)
+	replayFiles := []string{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		// Skip if not a file or doesn't have correct extension
+		if info.IsDir() || !strings.HasSuffix(path, extension) {
+			return nil
+		}
+		replayFiles = append(replayFiles, path)
+		return nil
+	})
+	return replayFiles, err
+}
@@ -0,0 +1,59 @@
+package parser_test
+
+import (
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+)
+
+// The parser tests build their replays with the encoder, so they live in parser_test to avoid an import cycle
+
+// testReplay is a 1v1 between alice (player 1) and bob (player 2) playing Zeus. Villagers train in 15 seconds and
+// Hoplites in 20, ClassicalAge and HeroicAge research in 60 seconds and Plow in 30.
+func testReplay(commands ...encoder.Command) encoder.Replay {
+	return encoder.Replay{
+		BuildString: "AoMRT_s.exe 601511 //stream/Athens/stable",
+		MapName:     "alfheim",
+		GameSeed:    42,
+		Players: []encoder.Player{
+			{Num: 1, Name: "alice", ProfileId: "1001", Team: 1, Color: 1, Civ: 1},
+			{Num: 2, Name: "bob", ProfileId: "1002", Team: 2, Color: 2, Civ: 1},
+		},
+		Xmbs: []encoder.XmbElement{
+			encoder.CivsXmb("Zeus"),
+			withPoints(encoder.ProtoXmb("Villager", "Hoplite", "House", "TownCenter"), "trainpoints", 15, 20),
+			withPoints(encoder.TechTreeXmb("ClassicalAge", "Plow", "HeroicAge"), "researchpoints", 60, 30, 60),
+			encoder.PowersXmb("Bolt"),
+		},
+		Commands: commands,
+	}
+}
+
+// writeReplay encodes replay into a temporary file and returns its path
+func writeReplay(t *testing.T, replay encoder.Replay) string {
+	t.Helper()
+	replayPath := filepath.Join(t.TempDir(), "test.mythrec")
+	if err := encoder.WriteFile(replayPath, replay, false); err != nil {
+		t.Fatalf("encoding failed: %v", err)
+	}
+	return replayPath
+}
+
+// at is the header of a command player issues at tick with sourceUnits selected
+func at(player int, tick int, sourceUnits ...uint32) encoder.CommandHeader {
+	return encoder.CommandHeader{Player: player, Tick: tick, SourceUnits: sourceUnits}
+}
+
+// withPoints adds a child element named pointsName to the first len(secs) entries of a catalog XMB, e.g., the
+// trainpoints of a proto
+func withPoints(catalog encoder.XmbElement, pointsName string, secs ...float64) encoder.XmbElement {
+	for i, value := range secs {
+		catalog.Children[i].Children = append(catalog.Children[i].Children, encoder.XmbElement{
+			Name:  pointsName,
+			Value: strconv.FormatFloat(value, 'f', 4, 64),
+		})
+	}
+	return catalog
+}
@@ -0,0 +1,339 @@
+package parser
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// A workbench for decoding command types whose layout is still a guess (e.g., UnknownCommand55). Every instance of a
+// command type is collected across a corpus of replays and each byte position of the command body is summarized:
+// which values it takes, how often it holds the same value and whether it moves with the game time, the player or
+// the commands around it. A position that is constant is likely padding or a flag, one that correlates with game time
+// is likely an id handed out sequentially (a unit id) and so on. Player ids and command types are categories rather
+// than quantities, so those associations are Cramér's V instead of a correlation.
+
+// researchTopValues is how many of the most common values are reported per byte position
+const researchTopValues = 5
+
+// researchTrailingBytes is how many bytes past the end of the layout a sample runs at most. A sample stops where the
+// next command starts, but that can be thousands of bytes of empty command lists away.
+const researchTrailingBytes = 16
+
+type commandSample struct {
+	body            []byte // The body as laid out followed by the bytes up to the next command
+	gameTimeSecs    float64
+	playerId        int
+	prevCommandType int
+	nextCommandType int
+}
+
+// ResearchCommandsInDir runs ResearchCommands on every replay in dir
+func ResearchCommandsInDir(dir string, commandType int, isGzip bool) (CommandResearch, error) {
+	replayPaths, err := findReplayFiles(dir, isGzip)
+	if err != nil {
+		return CommandResearch{}, err
+	}
+	if len(replayPaths) == 0 {
+		return CommandResearch{}, fmt.Errorf("no %s files found in %s", replayExtension(isGzip), dir)
+	}
+	return ResearchCommands(replayPaths, commandType, isGzip)
+}
+
+// ResearchCommands collects every command of commandType in the replays and summarizes each byte position of the
+// command body. Replays that fail to parse are skipped with a warning, a corpus usually contains a few broken ones.
+func ResearchCommands(replayPaths []string, commandType int, isGzip bool) (CommandResearch, error) {
+	research := CommandResearch{
+		CommandType: commandType,
+		ByteLengths: make(map[int]int),
+		Positions:   make([]BytePositionStats, 0),
+	}
+
+	samples := make([]commandSample, 0)
+	for _, replayPath := range replayPaths {
+		replay, err := readReplay(replayPath, isGzip)
+		if err != nil {
+			slog.Warn("Skipping replay", "path", replayPath, "error", err)
+			continue
+		}
+		research.NumReplays++
+
+		commandList := replay.commandList
+		for i, command := range commandList {
+			if command.CommandType() != commandType {
+				continue
+			}
+			nextOffset := command.OffsetEnd()
+			if i < len(commandList)-1 {
+				nextOffset = commandList[i+1].OffsetStart()
+			}
+			sample := commandSample{
+				body:            sampleBytes(&replay.rawData, command, nextOffset),
+				gameTimeSecs:    command.GameTimeSecs(),
+				playerId:        command.PlayerId(),
+				prevCommandType: -1,
+				nextCommandType: -1,
+			}
+			if i > 0 {
+				sample.prevCommandType = commandList[i-1].CommandType()
+			}
+			if i < len(commandList)-1 {
+				sample.nextCommandType = commandList[i+1].CommandType()
+			}
+			samples = append(samples, sample)
+			research.ByteLengths[command.ByteLength()]++
+		}
+	}
+
+	if research.NumReplays == 0 {
+		return research, fmt.Errorf("none of the %d replays could be read", len(replayPaths))
+	}
+	research.NumSamples = len(samples)
+
+	maxLength := 0
+	for _, sample := range samples {
+		maxLength = max(maxLength, len(sample.body))
+	}
+	for position := 0; position < maxLength; position++ {
+		research.Positions = append(research.Positions, researchPosition(samples, position))
+	}
+	return research, nil
+}
+
+// commandBody returns the bytes a refiner read for the command, i.e., everything after the source units, vectors and
+// preargument bytes.
+func commandBody(data *[]byte, command RawGameCommand) []byte {
+	return (*data)[command.OffsetEnd()-command.ByteLength() : command.OffsetEnd()]
+}
+
+// sampleBytes returns the body of the command followed by the bytes up to nextOffset, at most researchTrailingBytes of
+// them. The layout of a command being researched is a guess, the bytes past it show whether the guess stops short.
+func sampleBytes(data *[]byte, command RawGameCommand, nextOffset int) []byte {
+	endOffset := min(max(nextOffset, command.OffsetEnd()), command.OffsetEnd()+researchTrailingBytes, len(*data))
+	return (*data)[command.OffsetEnd()-command.ByteLength() : endOffset]
+}
+
+func researchPosition(samples []commandSample, position int) BytePositionStats {
+	stats := BytePositionStats{Position: position}
+	valueCounts := make(map[uint8]int)
+	values := make([]float64, 0)
+	gameTimes := make([]float64, 0)
+	players := make([]int, 0)
+	prevCommands := make([]int, 0)
+	nextCommands := make([]int, 0)
+
+	// sameAsPrevious compares against the previous sample of the same player, ids that are reused across commands
+	// (e.g., the building something is queued in) show up as a high rate
+	lastValueByPlayer := make(map[int]uint8)
+	numCompared := 0
+	numSame := 0
+	for _, sample := range samples {
+		if position >= len(sample.body) {
+			continue
+		}
+		value := sample.body[position]
+		valueCounts[value]++
+		values = append(values, float64(value))
+		gameTimes = append(gameTimes, sample.gameTimeSecs)
+		players = append(players, sample.playerId)
+		prevCommands = append(prevCommands, sample.prevCommandType)
+		nextCommands = append(nextCommands, sample.nextCommandType)
+
+		if lastValue, exists := lastValueByPlayer[sample.playerId]; exists {
+			numCompared++
+			if lastValue == value {
+				numSame++
+			}
+		}
+		lastValueByPlayer[sample.playerId] = value
+	}
+
+	stats.NumSamples = len(values)
+	stats.DistinctValues = len(valueCounts)
+	if stats.NumSamples == 0 {
+		return stats
+	}
+
+	topValues := make([]ByteValueCount, 0, len(valueCounts))
+	for value, count := range valueCounts {
+		topValues = append(topValues, ByteValueCount{Value: value, Count: count})
+	}
+	sort.Slice(topValues, func(i, j int) bool {
+		if topValues[i].Count != topValues[j].Count {
+			return topValues[i].Count > topValues[j].Count
+		}
+		return topValues[i].Value < topValues[j].Value
+	})
+	stats.ConstantRate = float64(topValues[0].Count) / float64(stats.NumSamples)
+	if len(topValues) > researchTopValues {
+		topValues = topValues[:researchTopValues]
+	}
+	stats.TopValues = topValues
+
+	if numCompared > 0 {
+		stats.SameAsPreviousRate = float64(numSame) / float64(numCompared)
+	}
+	stats.TimeCorrelation = correlation(values, gameTimes)
+	stats.PlayerAssociation = cramersV(values, players)
+	stats.PrevCommandAssociation = cramersV(values, prevCommands)
+	stats.NextCommandAssociation = cramersV(values, nextCommands)
+	return stats
+}
+
+// correlation is the Pearson correlation coefficient of xs and ys, 0 when either doesn't vary
+func correlation(xs []float64, ys []float64) float64 {
+	n := float64(len(xs))
+	if n < 2 {
+		return 0
+	}
+	var sumX, sumY float64
+	for i := range xs {
+		sumX += xs[i]
+		sumY += ys[i]
+	}
+	meanX := sumX / n
+	meanY := sumY / n
+
+	var covariance, varianceX, varianceY float64
+	for i := range xs {
+		dx := xs[i] - meanX
+		dy := ys[i] - meanY
+		covariance += dx * dy
+		varianceX += dx * dx
+		varianceY += dy * dy
+	}
+	if varianceX == 0 || varianceY == 0 {
+		return 0
+	}
+	return covariance / math.Sqrt(varianceX*varianceY)
+}
+
+// cramersV is the association between the byte values and a category of each sample, from 0 (independent) to 1 (one
+// determines the other). It is 0 when either takes a single value.
+func cramersV(values []float64, categories []int) float64 {
+	type cell struct {
+		value    float64
+		category int
+	}
+	valueCounts := make(map[float64]int)
+	categoryCounts := make(map[int]int)
+	cellCounts := make(map[cell]int)
+	for i, value := range values {
+		valueCounts[value]++
+		categoryCounts[categories[i]]++
+		cellCounts[cell{value, categories[i]}]++
+	}
+	k := min(len(valueCounts), len(categoryCounts))
+	if k < 2 {
+		return 0
+	}
+
+	// Chi-squared of the contingency table, the cells that never occur contribute their expected count
+	n := float64(len(values))
+	chiSquared := 0.0
+	for value, valueCount := range valueCounts {
+		for category, categoryCount := range categoryCounts {
+			expected := float64(valueCount) * float64(categoryCount) / n
+			observed := float64(cellCounts[cell{value, category}])
+			chiSquared += (observed - expected) * (observed - expected) / expected
+		}
+	}
+	return math.Sqrt(chiSquared / (n * float64(k-1)))
+}
+
+func CommandResearchToCsv(research CommandResearch) (string, error) {
+	var buffer bytes.Buffer
+	writer := csv.NewWriter(&buffer)
+	header := []string{
+		"position",
+		"samples",
+		"distinctValues",
+		"constantRate",
+		"sameAsPreviousRate",
+		"timeCorrelation",
+		"playerAssociation",
+		"prevCommandAssociation",
+		"nextCommandAssociation",
+		"topValues",
+	}
+	if err := writer.Write(header); err != nil {
+		return "", err
+	}
+	for _, position := range research.Positions {
+		row := []string{
+			strconv.Itoa(position.Position),
+			strconv.Itoa(position.NumSamples),
+			strconv.Itoa(position.DistinctValues),
+			formatResearchFloat(position.ConstantRate),
+			formatResearchFloat(position.SameAsPreviousRate),
+			formatResearchFloat(position.TimeCorrelation),
+			formatResearchFloat(position.PlayerAssociation),
+			formatResearchFloat(position.PrevCommandAssociation),
+			formatResearchFloat(position.NextCommandAssociation),
+			formatTopValues(position.TopValues),
+		}
+		if err := writer.Write(row); err != nil {
+			return "", err
+		}
+	}
+	writer.Flush()
+	return buffer.String(), writer.Error()
+}
+
+func CommandResearchToText(research CommandResearch) string {
+	var sb strings.Builder
+	sb.WriteString(fmt.Sprintf(
+		"command type %d: %d samples in %d replays\n",
+		research.CommandType,
+		research.NumSamples,
+		research.NumReplays,
+	))
+
+	lengths := make([]int, 0, len(research.ByteLengths))
+	for length := range research.ByteLengths {
+		lengths = append(lengths, length)
+	}
+	sort.Ints(lengths)
+	for _, length := range lengths {
+		sb.WriteString(fmt.Sprintf("  body length %d: %d samples\n", length, research.ByteLengths[length]))
+	}
+
+	sb.WriteString(fmt.Sprintf(
+		"\n%4s %8s %8s %8s %8s %8s %8s %8s %8s  %s\n",
+		"pos", "samples", "distinct", "const", "same", "time", "player", "prev", "next", "top values",
+	))
+	for _, position := range research.Positions {
+		sb.WriteString(fmt.Sprintf(
+			"%4d %8d %8d %8.3f %8.3f %8.3f %8.3f %8.3f %8.3f  %s\n",
+			position.Position,
+			position.NumSamples,
+			position.DistinctValues,
+			position.ConstantRate,
+			position.SameAsPreviousRate,
+			position.TimeCorrelation,
+			position.PlayerAssociation,
+			position.PrevCommandAssociation,
+			position.NextCommandAssociation,
+			formatTopValues(position.TopValues),
+		))
+	}
+	return sb.String()
+}
+
+func formatResearchFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', 4, 64)
+}
+
+// formatTopValues formats values as "0x00:120 0x01:3", hex because most of what we look for are flags and ids
+func formatTopValues(topValues []ByteValueCount) string {
+	parts := make([]string, 0, len(topValues))
+	for _, topValue := range topValues {
+		parts = append(parts, fmt.Sprintf("0x%02x:%d", topValue.Value, topValue.Count))
+	}
+	return strings.Join(parts, " ")
+}
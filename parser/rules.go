@@ -0,0 +1,311 @@
+package parser
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// A small declarative rule language evaluated over the formatted command stream (GameCommands) and the players'
+// metadata. It exists so that detections like "Titan before 25 minutes" or "god power used within 10 seconds of an
+// age-up" can be written as data instead of one-off Go code. Rules are YAML, see rules/checks.yaml for examples:
+//
+//	rules:
+//	  - name: Titan before 25 minutes
+//	    match:
+//	      commandType: godPower
+//	      payload: {Name: TitanGate}
+//	      beforeSecs: 1500
+//
+// Every rule is evaluated for every player. A rule fires for a player when the number of that player's commands
+// accepted by Match (and Within, if set) is between MinCount and MaxCount.
+
+type RuleSet struct {
+	Rules []Rule `yaml:"rules"`
+}
+
+// Rule is a single detection. Player filters which players the rule is evaluated for, it maps a ReplayPlayer field
+// (e.g., "God", "Winner", "Opening") to a glob matched against the field's value. The number of matching commands
+// must be in the CountRange.
+type Rule struct {
+	Name       string            `yaml:"name"`
+	Player     map[string]string `yaml:"player"`
+	Match      CommandMatcher    `yaml:"match"`
+	Within     *WithinCondition  `yaml:"within"`
+	CountRange `yaml:",inline"`
+}
+
+// CommandMatcher accepts formatted commands. CommandType is a glob matched against the command type. Payload maps a
+// payload field to a glob matched against its value, nested fields use dots (e.g., "Location1.X") and commands whose
+// payload is a plain string expose it as "Value". PayloadMin and PayloadMax bound numeric payload fields. Only commands
+// issued in the TimeWindow are accepted. Globs can list alternatives separated by "|", see globMatches.
+type CommandMatcher struct {
+	CommandType string             `yaml:"commandType"`
+	Payload     map[string]string  `yaml:"payload"`
+	PayloadMin  map[string]float64 `yaml:"payloadMin"`
+	PayloadMax  map[string]float64 `yaml:"payloadMax"`
+	TimeWindow  `yaml:",inline"`
+}
+
+// WithinCondition requires a command matched by Of to have happened at most Secs seconds before the command being
+// checked. By default Of must be issued by the same player, set AnyPlayer to look at every player's commands.
+type WithinCondition struct {
+	Secs      float64        `yaml:"secs"`
+	Of        CommandMatcher `yaml:"of"`
+	AnyPlayer bool           `yaml:"anyPlayer"`
+}
+
+func LoadRules(rulesPath string) (*RuleSet, error) {
+	var ruleSet RuleSet
+	if err := loadRulesFile(rulesPath, &ruleSet); err != nil {
+		return nil, fmt.Errorf("error reading rules %s: %w", rulesPath, err)
+	}
+
+	for i, rule := range ruleSet.Rules {
+		if rule.Name == "" {
+			return nil, fmt.Errorf("rule %d has no name", i)
+		}
+		patterns := []string{rule.Match.CommandType}
+		for _, pattern := range rule.Match.Payload {
+			patterns = append(patterns, pattern)
+		}
+		for _, pattern := range rule.Player {
+			patterns = append(patterns, pattern)
+		}
+		if rule.Within != nil {
+			patterns = append(patterns, rule.Within.Of.CommandType)
+			for _, pattern := range rule.Within.Of.Payload {
+				patterns = append(patterns, pattern)
+			}
+		}
+		for _, pattern := range patterns {
+			if err := validatePattern(pattern); err != nil {
+				return nil, fmt.Errorf("rule %s has a bad pattern %q: %w", rule.Name, pattern, err)
+			}
+		}
+	}
+	slog.Debug("Loaded rules", "path", rulesPath, "numRules", len(ruleSet.Rules))
+	return &ruleSet, nil
+}
+
+func CheckRulesToJson(replayPaths []string, ruleSet *RuleSet, prettyPrint bool, isGzip bool) (string, error) {
+	results, err := CheckRules(replayPaths, ruleSet, isGzip)
+	if err != nil {
+		return "", err
+	}
+
+	var jsonBytes []byte
+	if prettyPrint {
+		jsonBytes, err = json.MarshalIndent(results, "", "    ")
+	} else {
+		jsonBytes, err = json.Marshal(results)
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(jsonBytes), nil
+}
+
+func CheckRulesToText(replayPaths []string, ruleSet *RuleSet, isGzip bool) (string, error) {
+	results, err := CheckRules(replayPaths, ruleSet, isGzip)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	for _, result := range results {
+		sb.WriteString(fmt.Sprintf("%s: %d rule(s) fired\n", result.ReplayPath, len(result.Fired)))
+		for _, fired := range result.Fired {
+			sb.WriteString(fmt.Sprintf(
+				"  player %d (%s): %s, %dx, first at %s\n",
+				fired.PlayerNum,
+				fired.PlayerName,
+				fired.Rule,
+				fired.Count,
+				formatGameTime(fired.FirstGameTimeSecs),
+			))
+		}
+	}
+	return sb.String(), nil
+}
+
+// CheckRules parses each replay and reports which rules fired for which players.
+func CheckRules(replayPaths []string, ruleSet *RuleSet, isGzip bool) ([]RuleCheckResult, error) {
+	results := make([]RuleCheckResult, 0)
+	for _, replayPath := range replayPaths {
+		replay, err := Parse(replayPath, ParseOptions{IsGzip: isGzip})
+		if err != nil {
+			return results, fmt.Errorf("error parsing %s: %w", replayPath, err)
+		}
+		results = append(results, RuleCheckResult{
+			ReplayPath: replayPath,
+			Fired:      evaluateRules(&replay, ruleSet),
+		})
+	}
+	return results, nil
+}
+
+// ruleCommand is a formatted command with its payload flattened into a map, so that payload fields can be looked up
+// by name regardless of the payload's Go type.
+type ruleCommand struct {
+	ReplayGameCommand
+	fields map[string]interface{}
+}
+
+func evaluateRules(replay *ReplayFormatted, ruleSet *RuleSet) []FiredRule {
+	commands := make([]ruleCommand, 0)
+	if replay.GameCommands != nil {
+		for _, command := range *replay.GameCommands {
+			commands = append(commands, ruleCommand{
+				ReplayGameCommand: command,
+				fields:            flattenForRules(command.Payload),
+			})
+		}
+	}
+
+	fired := make([]FiredRule, 0)
+	for _, player := range replay.Players {
+		playerFields := flattenForRules(player)
+		for _, rule := range ruleSet.Rules {
+			if !playerMatches(playerFields, rule.Player) {
+				continue
+			}
+
+			count := 0
+			firstGameTimeSecs := 0.0
+			for i, command := range commands {
+				if command.PlayerNum != player.PlayerNum || !commandMatches(&command, &rule.Match) {
+					continue
+				}
+				if rule.Within != nil && !withinMatches(commands, i, rule.Within) {
+					continue
+				}
+				if count == 0 {
+					firstGameTimeSecs = command.GameTimeSecs
+				}
+				count++
+			}
+
+			if !rule.allows(count) {
+				continue
+			}
+			slog.Debug("Rule fired", "rule", rule.Name, "playerNum", player.PlayerNum, "count", count)
+			fired = append(fired, FiredRule{
+				Rule:              rule.Name,
+				PlayerNum:         player.PlayerNum,
+				PlayerName:        player.Name,
+				Count:             count,
+				FirstGameTimeSecs: firstGameTimeSecs,
+			})
+		}
+	}
+	return fired
+}
+
+func withinMatches(commands []ruleCommand, idx int, within *WithinCondition) bool {
+	command := commands[idx]
+	// Commands are in game time order, so walk backwards until we are out of the window
+	for i := idx - 1; i >= 0; i-- {
+		anchor := commands[i]
+		if command.GameTimeSecs-anchor.GameTimeSecs > within.Secs {
+			return false
+		}
+		if !within.AnyPlayer && anchor.PlayerNum != command.PlayerNum {
+			continue
+		}
+		if commandMatches(&anchor, &within.Of) {
+			return true
+		}
+	}
+	return false
+}
+
+func commandMatches(command *ruleCommand, matcher *CommandMatcher) bool {
+	if !matcher.includes(command.GameTimeSecs) {
+		return false
+	}
+	if !globMatches(matcher.CommandType, command.CommandType) {
+		return false
+	}
+	for field, pattern := range matcher.Payload {
+		value, exists := lookupRuleField(command.fields, field)
+		if !exists || !globMatches(pattern, fmt.Sprint(value)) {
+			return false
+		}
+	}
+	for field, min := range matcher.PayloadMin {
+		value, ok := numericRuleField(command.fields, field)
+		if !ok || value < min {
+			return false
+		}
+	}
+	for field, max := range matcher.PayloadMax {
+		value, ok := numericRuleField(command.fields, field)
+		if !ok || value > max {
+			return false
+		}
+	}
+	return true
+}
+
+func playerMatches(playerFields map[string]interface{}, filters map[string]string) bool {
+	for field, pattern := range filters {
+		value, exists := lookupRuleField(playerFields, field)
+		if !exists || !globMatches(pattern, fmt.Sprint(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// flattenForRules turns a payload (or player) into a generic map by round tripping it through JSON, this way rules
+// see exactly the field names that appear in the parse output. Non-object values are exposed as "Value".
+func flattenForRules(value interface{}) map[string]interface{} {
+	jsonBytes, err := json.Marshal(value)
+	if err != nil {
+		slog.Debug("Could not flatten value for rules", "error", err)
+		return map[string]interface{}{}
+	}
+	var generic interface{}
+	if err := json.Unmarshal(jsonBytes, &generic); err != nil {
+		return map[string]interface{}{}
+	}
+	if fields, ok := generic.(map[string]interface{}); ok {
+		return fields
+	}
+	return map[string]interface{}{"Value": generic}
+}
+
+func lookupRuleField(fields map[string]interface{}, field string) (interface{}, bool) {
+	var current interface{} = fields
+	for _, part := range strings.Split(field, ".") {
+		currentMap, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = currentMap[part]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+func numericRuleField(fields map[string]interface{}, field string) (float64, bool) {
+	value, exists := lookupRuleField(fields, field)
+	if !exists {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case string:
+		parsed, err := strconv.ParseFloat(v, 64)
+		return parsed, err == nil
+	}
+	return 0, false
+}
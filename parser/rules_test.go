@@ -0,0 +1,157 @@
+package parser_test
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+// bolt is the protoPowerId of testReplay's only god power
+const bolt = 0
+
+// withGodPowers flags every power of testReplay as a god power, the formatter reports them as godPower commands then
+func withGodPowers(replay encoder.Replay) encoder.Replay {
+	powers := replay.Xmbs[3]
+	for i := range powers.Children {
+		powers.Children[i].Attributes = append(powers.Children[i].Attributes, encoder.XmbAttribute{Name: "godpower", Value: "1"})
+	}
+	return replay
+}
+
+// firedRules checks ruleSet against the replay and returns "<player name>: <rule>" for every rule that fired
+func firedRules(t *testing.T, replay encoder.Replay, ruleSet *parser.RuleSet) []string {
+	t.Helper()
+	results, err := parser.CheckRules([]string{writeReplay(t, replay)}, ruleSet, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fired := make([]string, 0)
+	for _, rule := range results[0].Fired {
+		fired = append(fired, rule.PlayerName+": "+rule.Rule)
+	}
+	sort.Strings(fired)
+	return fired
+}
+
+func TestChecksRules(t *testing.T) {
+	ruleSet, err := parser.LoadRules(filepath.Join("..", "rules", "checks.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	replay := withGodPowers(testReplay(
+		// alice prequeues the Classical Age and bolts right after, bob bolts right after researching Plow
+		encoder.PrequeueTech{CommandHeader: at(1, 100, 100), TechId: classicalAge},
+		encoder.Research{CommandHeader: at(2, 100, 200), TechId: plow},
+		encoder.UseProtoPower{CommandHeader: at(1, 200), ProtoPowerId: bolt},
+		encoder.UseProtoPower{CommandHeader: at(2, 200), ProtoPowerId: bolt},
+		// bob's Heroic Age is 20 seconds before his next bolt
+		encoder.Research{CommandHeader: at(2, 1000, 200), TechId: heroicAge},
+		encoder.UseProtoPower{CommandHeader: at(2, 1400), ProtoPowerId: bolt},
+		encoder.MarketBuySellResources{CommandHeader: at(1, 1600, 300), ResourceType: wood, Quantity: -600},
+		encoder.MarketBuySellResources{CommandHeader: at(2, 1600, 400), ResourceType: wood, Quantity: -100},
+		encoder.Resign{CommandHeader: at(2, 2000)},
+	))
+
+	want := []string{
+		"alice: God power within 10 seconds of an age-up",
+		"alice: Large market sell",
+	}
+	if fired := firedRules(t, replay, ruleSet); !reflect.DeepEqual(fired, want) {
+		t.Errorf("fired = %v, want %v", fired, want)
+	}
+}
+
+func TestRules(t *testing.T) {
+	replay := withGodPowers(testReplay(
+		encoder.Train{CommandHeader: at(1, 20, 100), ProtoUnitId: villager},
+		encoder.PrequeueTech{CommandHeader: at(1, 40, 100), TechId: plow},
+		encoder.Research{CommandHeader: at(2, 60, 200), TechId: plow},
+		encoder.UseProtoPower{CommandHeader: at(1, 100), ProtoPowerId: bolt},
+		encoder.Resign{CommandHeader: at(2, 200)},
+	))
+	tests := []struct {
+		name  string
+		rules string
+		fired []string
+	}{
+		{
+			name: "alternatives",
+			rules: `
+rules:
+  - name: Plow
+    match: {commandType: research|prequeueTech, payload: {Value: Plow|Axe}}`,
+			fired: []string{"alice: Plow", "bob: Plow"},
+		},
+		{
+			name: "player filter",
+			rules: `
+rules:
+  - name: Zeus loser
+    player: {God: Zeus, Winner: "false"}
+    match: {commandType: research}`,
+			fired: []string{"bob: Zeus loser"},
+		},
+		{
+			name: "maxCount 0 fires for players who never did it",
+			rules: `
+rules:
+  - name: No villagers
+    match: {commandType: train, payload: {Value: Villager}}
+    maxCount: 0`,
+			fired: []string{"bob: No villagers"},
+		},
+		{
+			name: "time window",
+			rules: `
+rules:
+  - name: Early god power
+    match: {commandType: godPower, beforeSecs: 6}
+  - name: Plow after 2 seconds
+    match: {commandType: research|prequeueTech, afterSecs: 2.5}`,
+			fired: []string{"alice: Early god power", "bob: Plow after 2 seconds"},
+		},
+		{
+			name: "within looks at the same player unless anyPlayer is set",
+			rules: `
+rules:
+  - name: Bolt after own Plow
+    match: {commandType: godPower}
+    within: {secs: 3, of: {commandType: research, payload: {Value: Plow}}}
+  - name: Bolt after any Plow
+    match: {commandType: godPower}
+    within: {secs: 3, of: {commandType: research, payload: {Value: Plow}}, anyPlayer: true}`,
+			fired: []string{"alice: Bolt after any Plow"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+			if err := os.WriteFile(rulesPath, []byte(test.rules), 0644); err != nil {
+				t.Fatal(err)
+			}
+			ruleSet, err := parser.LoadRules(rulesPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fired := firedRules(t, replay, ruleSet); !reflect.DeepEqual(fired, test.fired) {
+				t.Errorf("fired = %v, want %v", fired, test.fired)
+			}
+		})
+	}
+}
+
+func TestLoadRulesRejectsBadPatterns(t *testing.T) {
+	rulesPath := filepath.Join(t.TempDir(), "rules.yaml")
+	rules := "rules:\n  - name: Bad\n    match: {commandType: \"research|[\"}\n"
+	if err := os.WriteFile(rulesPath, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parser.LoadRules(rulesPath); err == nil {
+		t.Error("a bad pattern in an alternative was accepted")
+	}
+}
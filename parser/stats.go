@@ -1,7 +1,9 @@
 package parser
 
 import (
+	"log/slog"
 	"math"
+	"sort"
 )
 
 // What stats do I want?
@@ -19,7 +21,11 @@ import (
 //   e. Researched techs
 // 7. eAPM throughout the game
 
-func calcStats(commandList *[]ReplayGameCommand, rawCommandList *[]RawGameCommand) *map[int]ReplayStats {
+func calcStats(
+	commandList *[]ReplayGameCommand,
+	rawCommandList *[]RawGameCommand,
+	formatterInput FormatterInput,
+) *map[int]ReplayStats {
 	statsByPlayer := make(map[int]ReplayStats)
 	commandsByPlayer := make(map[int][]ReplayGameCommand)
 	for _, command := range *commandList {
@@ -38,51 +44,91 @@ func calcStats(commandList *[]ReplayGameCommand, rawCommandList *[]RawGameComman
 	}
 
 	for playerNum, commands := range commandsByPlayer {
-		statsByPlayer[playerNum] = calcStatsForPlayer(&commands, rawCommandsByPlayer[playerNum])
+		statsByPlayer[playerNum] = calcStatsForPlayer(&commands, rawCommandsByPlayer[playerNum], formatterInput)
 	}
+	addTributesReceived(commandList, &statsByPlayer)
 	return &statsByPlayer
 }
 
-func calcStatsForPlayer(playerCommandList *[]ReplayGameCommand, rawPlayerCommandList []RawGameCommand) ReplayStats {
+func calcStatsForPlayer(
+	playerCommandList *[]ReplayGameCommand,
+	rawPlayerCommandList []RawGameCommand,
+	formatterInput FormatterInput,
+) ReplayStats {
 	totals := calcTotals(playerCommandList)
 	timelines := calcTimelines(playerCommandList)
+	production := calcProduction(rawPlayerCommandList, formatterInput)
+	addProductionToTimelines(&timelines.Timelines, production)
+	controlGroups, controlGroupTimeline := calcControlGroups(playerCommandList)
+	timelines.Timelines.ControlGroups = controlGroupTimeline
+	timelines.Timelines.UnderRaid = calcUnderRaid(playerCommandList)
+	timelines.Timelines.Walls = calcWalls(playerCommandList)
+	wallLength := 0.0
+	for _, wall := range timelines.Timelines.Walls {
+		wallLength += wall.Length
+	}
+	underRaidSecs := 0.0
+	for _, period := range timelines.Timelines.UnderRaid {
+		underRaidSecs += period.DurationSecs
+	}
 
 	return ReplayStats{
 		Trade: TradeStats{
 			ResourcesSold:   totals.Trade.ResourcesSold,
 			ResourcesBought: totals.Trade.ResourcesBought,
 		},
-		UnitCounts:      totals.UnitCounts,
-		BuildingCounts:  totals.BuildingCounts,
-		GodPowerCounts:  totals.GodPowerCounts,
-		TechsResearched: totals.TechsResearched,
-		FormationCounts: totals.FormationCounts,
-		TauntCounts:     totals.TauntCounts,
-		EAPM:            calcEAPMOverTime(&rawPlayerCommandList),
-		Timelines:       timelines.Timelines,
+		UnitCounts:           production.netUnits,
+		BuildingCounts:       production.buildings,
+		GodPowerCounts:       totals.GodPowerCounts,
+		TechsResearched:      production.netTechs,
+		FormationCounts:      totals.FormationCounts,
+		TauntCounts:          totals.TauntCounts,
+		TributeSent:          totals.TributeSent,
+		TributeReceived:      make(map[string]float32),
+		GrossUnitCounts:      production.grossUnits,
+		GrossBuildingCounts:  production.buildings,
+		GrossTechsResearched: production.grossTechs,
+		CancelledCounts:      production.cancelledCounts,
+		SelfDeleteCounts:     totals.SelfDeleteCounts,
+		TransformCounts:      totals.TransformCounts,
+		StanceCounts:         totals.StanceCounts,
+		PrebuyGodPowerCounts: totals.PrebuyGodPowerCounts,
+		AutoAbilityToggles:   totals.AutoAbilityToggles,
+		ControlGroups:        controlGroups,
+		UnderRaidSecs:        underRaidSecs,
+		WallSegments:         len(timelines.Timelines.Walls),
+		WallLength:           wallLength,
+		EcoLayout:            calcEcoLayout(playerCommandList, dropsitesOf(formatterInput.protoRootNode)),
+		EAPM:                 calcEAPMOverTime(&rawPlayerCommandList),
+		Timelines:            timelines.Timelines,
 	}
 }
 
 func calcTotals(playerCommandList *[]ReplayGameCommand) ReplayStats {
 	resourcesSold := make(map[string]float32)
 	resourcesBought := make(map[string]float32)
-	unitCounts := make(map[string]int)
-	buildingCounts := make(map[string]int)
 	godPowerCounts := make(map[string]int)
-	techsResearched := make([]string, 0)
-	seenTechs := make(map[string]bool)
 	formationCounts := make(map[string]int)
 	tauntCounts := make(map[string]int)
+	tributeSent := make(map[string]float32)
+	selfDeleteCounts := make(map[string]int)
+	transformCounts := make(map[string]int)
+	stanceCounts := make(map[string]int)
+	prebuyGodPowerCounts := make(map[string]int)
+	autoAbilityToggles := make(map[string]int)
 
 	for _, command := range *playerCommandList {
 		handleMarketBuySell("sell", &command, &resourcesSold)
 		handleMarketBuySell("buy", &command, &resourcesBought)
-		handleUnitCounts(&command, &unitCounts)
-		handleBuildingCounts(&command, &buildingCounts)
 		handleGodPowerCounts(&command, &godPowerCounts)
 		handleFormationCount(&command, &formationCounts)
 		handleTauntCount(&command, &tauntCounts)
-		techsResearched = techsResearch(&command, techsResearched, &seenTechs)
+		handleTributeSent(&command, &tributeSent)
+		handleSelfDeleteCount(&command, &selfDeleteCounts)
+		handleTransformCount(&command, &transformCounts)
+		handleStanceCount(&command, &stanceCounts)
+		handlePrebuyGodPowerCount(&command, &prebuyGodPowerCounts)
+		handleAutoAbilityToggleCount(&command, &autoAbilityToggles)
 	}
 
 	return ReplayStats{
@@ -90,23 +136,28 @@ func calcTotals(playerCommandList *[]ReplayGameCommand) ReplayStats {
 			ResourcesSold:   resourcesSold,
 			ResourcesBought: resourcesBought,
 		},
-		UnitCounts:      unitCounts,
-		BuildingCounts:  buildingCounts,
-		GodPowerCounts:  godPowerCounts,
-		FormationCounts: formationCounts,
-		TechsResearched: techsResearched,
-		TauntCounts:     tauntCounts,
+		GodPowerCounts:       godPowerCounts,
+		FormationCounts:      formationCounts,
+		TauntCounts:          tauntCounts,
+		TributeSent:          tributeSent,
+		SelfDeleteCounts:     selfDeleteCounts,
+		TransformCounts:      transformCounts,
+		StanceCounts:         stanceCounts,
+		PrebuyGodPowerCounts: prebuyGodPowerCounts,
+		AutoAbilityToggles:   autoAbilityToggles,
 	}
 }
 
 func calcTimelines(playerCommandList *[]ReplayGameCommand) ReplayStats {
 	lastCommand := (*playerCommandList)[len(*playerCommandList)-1]
 	minutes := int(math.Ceil(lastCommand.GameTimeSecs / 60.0))
+	// The unit and building counts are filled in from the production ledger, see addProductionToTimelines
 	unitCounts := make([]map[string]int, minutes)
 	buildingCounts := make([]map[string]int, minutes)
 	techsPrequeued := make([]TechItem, 0)
 	techsResearched := make([]TechItem, 0)
 	godPowers := make([]GodPowerItem, 0)
+	tributes := make([]TributeItem, 0)
 
 	for _, command := range *playerCommandList {
 		commandMinute := int(math.Ceil(command.GameTimeSecs / 60.0))
@@ -116,11 +167,10 @@ func calcTimelines(playerCommandList *[]ReplayGameCommand) ReplayStats {
 		if buildingCounts[commandMinute-1] == nil {
 			buildingCounts[commandMinute-1] = make(map[string]int)
 		}
-		handleUnitCounts(&command, &unitCounts[commandMinute-1])
-		handleBuildingCounts(&command, &buildingCounts[commandMinute-1])
 		techsPrequeued = getResearchOrPrequeue(&command, techsPrequeued, "prequeueTech")
 		techsResearched = getResearchOrPrequeue(&command, techsResearched, "research")
 		godPowers = handleGodPower(&command, godPowers)
+		tributes = handleTribute(&command, tributes)
 	}
 
 	return ReplayStats{
@@ -130,6 +180,7 @@ func calcTimelines(playerCommandList *[]ReplayGameCommand) ReplayStats {
 			TechsPrequeued:  techsPrequeued,
 			TechsResearched: techsResearched,
 			GodPowers:       godPowers,
+			Tributes:        tributes,
 		},
 	}
 }
@@ -146,26 +197,6 @@ func handleMarketBuySell(buySell string, command *ReplayGameCommand, resources *
 	}
 }
 
-func handleUnitCounts(command *ReplayGameCommand, unitCounts *map[string]int) {
-	if command.CommandType == "train" {
-		payload := command.Payload.(string)
-		if _, exists := (*unitCounts)[payload]; !exists {
-			(*unitCounts)[payload] = 0
-		}
-		(*unitCounts)[payload] += 1
-	}
-}
-
-func handleBuildingCounts(command *ReplayGameCommand, buildingCounts *map[string]int) {
-	if command.CommandType == "build" {
-		payload := command.Payload.(BuildCommandPaylod)
-		if _, exists := (*buildingCounts)[payload.Name]; !exists {
-			(*buildingCounts)[payload.Name] = 0
-		}
-		(*buildingCounts)[payload.Name] += 1
-	}
-}
-
 func handleGodPowerCounts(command *ReplayGameCommand, godPowerCounts *map[string]int) {
 	if command.CommandType == "godPower" {
 		payload := command.Payload.(ProtoPowerPayload)
@@ -196,15 +227,44 @@ func handleTauntCount(command *ReplayGameCommand, tauntCount *map[string]int) {
 	}
 }
 
-func techsResearch(command *ReplayGameCommand, techsResearched []string, seenTechs *map[string]bool) []string {
-	if command.CommandType == "research" || command.CommandType == "prequeueTech" {
-		payload := command.Payload.(string)
-		if _, exists := (*seenTechs)[payload]; !exists {
-			(*seenTechs)[payload] = true
-			techsResearched = append(techsResearched, payload)
+func handleSelfDeleteCount(command *ReplayGameCommand, selfDeleteCounts *map[string]int) {
+	if command.CommandType == "delete" {
+		payload := command.Payload.(DeletePayload)
+		for _, unit := range payload.Units {
+			(*selfDeleteCounts)[unit.Proto] += 1
+		}
+	}
+}
+
+func handleTransformCount(command *ReplayGameCommand, transformCounts *map[string]int) {
+	// Only finished transformations are counted, a transformation that is started but interrupted didn't happen
+	if command.CommandType == "transform" {
+		payload := command.Payload.(TransformPayload)
+		if payload.Finished {
+			(*transformCounts)[payload.To] += 1
 		}
 	}
-	return techsResearched
+}
+
+func handleStanceCount(command *ReplayGameCommand, stanceCounts *map[string]int) {
+	if command.CommandType == "stance" {
+		payload := command.Payload.(string)
+		(*stanceCounts)[payload] += 1
+	}
+}
+
+func handlePrebuyGodPowerCount(command *ReplayGameCommand, prebuyGodPowerCounts *map[string]int) {
+	if command.CommandType == "prebuyGodPower" {
+		payload := command.Payload.(string)
+		(*prebuyGodPowerCounts)[payload] += 1
+	}
+}
+
+func handleAutoAbilityToggleCount(command *ReplayGameCommand, autoAbilityToggles *map[string]int) {
+	if command.CommandType == "toggleAutoAbility" {
+		payload := command.Payload.(ToggleAutoAbilityPayload)
+		(*autoAbilityToggles)[payload.Unit] += 1
+	}
 }
 
 func getResearchOrPrequeue(command *ReplayGameCommand, techsResearched []TechItem, matching string) []TechItem {
@@ -229,6 +289,207 @@ func handleGodPower(command *ReplayGameCommand, godPowers []GodPowerItem) []GodP
 	return godPowers
 }
 
+func handleTributeSent(command *ReplayGameCommand, tributeSent *map[string]float32) {
+	if command.CommandType == "tribute" {
+		payload := command.Payload.(TributePayload)
+		(*tributeSent)[payload.ResourceType] += payload.Amount
+	}
+}
+
+func handleTribute(command *ReplayGameCommand, tributes []TributeItem) []TributeItem {
+	if command.CommandType == "tribute" {
+		payload := command.Payload.(TributePayload)
+		tributes = append(tributes, TributeItem{
+			GameTimeSecs: command.GameTimeSecs,
+			From:         command.PlayerNum,
+			To:           payload.Recipient,
+			ResourceType: payload.ResourceType,
+			Amount:       payload.Amount,
+		})
+	}
+	return tributes
+}
+
+// tributeReceivedAmount is what actually arrives at the recipient, the tribute tax rate is taken off the amount sent.
+// A tax that isn't a rate is ignored rather than turned into a negative amount.
+func tributeReceivedAmount(payload TributePayload) float32 {
+	if payload.Tax < 0 || payload.Tax >= 1 {
+		slog.Warn("Tribute tax isn't a rate between 0 and 1", "tax", payload.Tax)
+		return payload.Amount
+	}
+	return payload.Amount * (1 - payload.Tax)
+}
+
+func addTributesReceived(commandList *[]ReplayGameCommand, statsByPlayer *map[int]ReplayStats) {
+	// Tributes are commands of the sender, so the recipient's side can only be filled in once every player's stats
+	// have been calculated.
+	recipients := make(map[int]bool)
+	for _, command := range *commandList {
+		if command.CommandType != "tribute" {
+			continue
+		}
+		payload := command.Payload.(TributePayload)
+		stats, exists := (*statsByPlayer)[payload.Recipient]
+		if !exists {
+			slog.Debug("Tribute recipient has no stats", "recipient", payload.Recipient)
+			continue
+		}
+		stats.TributeReceived[payload.ResourceType] += tributeReceivedAmount(payload)
+		stats.Timelines.Tributes = append(stats.Timelines.Tributes, TributeItem{
+			GameTimeSecs: command.GameTimeSecs,
+			From:         command.PlayerNum,
+			To:           payload.Recipient,
+			ResourceType: payload.ResourceType,
+			Amount:       tributeReceivedAmount(payload),
+		})
+		(*statsByPlayer)[payload.Recipient] = stats
+		recipients[payload.Recipient] = true
+	}
+
+	// The recipient's timeline already holds the tributes they sent
+	for recipient := range recipients {
+		tributes := (*statsByPlayer)[recipient].Timelines.Tributes
+		sort.SliceStable(tributes, func(i, j int) bool {
+			return tributes[i].GameTimeSecs < tributes[j].GameTimeSecs
+		})
+	}
+}
+
+// calcTeamTributes builds the team to team tribute flow matrix. Tributes within a team show up as a flow from the
+// team to itself.
+func calcTeamTributes(commandList *[]ReplayGameCommand, players *[]ReplayPlayer) []TeamTributeFlow {
+	teamByPlayer := make(map[int]int)
+	for _, player := range *players {
+		teamByPlayer[player.PlayerNum] = player.TeamId
+	}
+
+	flows := make([]TeamTributeFlow, 0)
+	flowIdx := make(map[TeamTributeFlow]int)
+	for _, command := range *commandList {
+		if command.CommandType != "tribute" {
+			continue
+		}
+		payload := command.Payload.(TributePayload)
+		key := TeamTributeFlow{
+			FromTeam:     teamByPlayer[command.PlayerNum],
+			ToTeam:       teamByPlayer[payload.Recipient],
+			ResourceType: payload.ResourceType,
+		}
+		idx, exists := flowIdx[key]
+		if !exists {
+			idx = len(flows)
+			flowIdx[key] = idx
+			flows = append(flows, key)
+		}
+		flows[idx].Amount += payload.Amount
+	}
+	return flows
+}
+
+// calcControlGroups collapses the unit by unit control group changes into one item per group and tick, and counts how
+// often each group was (re)assigned.
+func calcControlGroups(playerCommandList *[]ReplayGameCommand) (ControlGroupStats, []ControlGroupItem) {
+	items := make([]ControlGroupItem, 0)
+	tickItems := make(map[int]int)
+	tickSecs := -1.0
+	for _, command := range *playerCommandList {
+		if command.CommandType != "controlGroup" {
+			continue
+		}
+		if command.GameTimeSecs != tickSecs {
+			tickItems = make(map[int]int)
+			tickSecs = command.GameTimeSecs
+		}
+		payload := command.Payload.(ControlGroupPayload)
+		idx, exists := tickItems[payload.Group]
+		if !exists {
+			idx = len(items)
+			tickItems[payload.Group] = idx
+			items = append(items, ControlGroupItem{
+				GameTimeSecs: command.GameTimeSecs,
+				Group:        payload.Group,
+				Operation:    payload.Operation,
+			})
+		} else if items[idx].Operation != payload.Operation && items[idx].Operation != string(SetControlGroup) {
+			// The game sets a group by removing its old units and adding the new ones in the same tick, only the
+			// added units count towards the size of a set
+			if items[idx].Operation == string(RemoveFromControlGroup) {
+				items[idx].NumUnits = 0
+			}
+			items[idx].Operation = string(SetControlGroup)
+		}
+		if payload.Operation == string(RemoveFromControlGroup) && items[idx].Operation == string(SetControlGroup) {
+			continue
+		}
+		items[idx].NumUnits += len(payload.UnitIds)
+	}
+
+	stats := ControlGroupStats{
+		GroupsUsed:       make([]int, 0),
+		AssignmentCounts: make(map[int]int),
+	}
+	for _, item := range items {
+		if item.Operation != string(AddToControlGroup) && item.Operation != string(SetControlGroup) {
+			continue
+		}
+		if _, exists := stats.AssignmentCounts[item.Group]; !exists {
+			stats.GroupsUsed = append(stats.GroupsUsed, item.Group)
+		} else if item.Operation == string(SetControlGroup) {
+			stats.Reassignments++
+		}
+		stats.AssignmentCounts[item.Group]++
+	}
+	sort.Ints(stats.GroupsUsed)
+	return stats, items
+}
+
+// calcUnderRaid finds the periods a player spent garrisoned. Further town bells and seek shelters while a period is
+// open are part of the same raid, the first ungarrison ends it.
+func calcUnderRaid(playerCommandList *[]ReplayGameCommand) []RaidPeriod {
+	periods := make([]RaidPeriod, 0)
+	var open *RaidPeriod
+	for _, command := range *playerCommandList {
+		switch command.CommandType {
+		case "townBell", "seekShelter":
+			if open == nil {
+				open = &RaidPeriod{
+					StartSecs: command.GameTimeSecs,
+					Trigger:   command.CommandType,
+				}
+			}
+		case "ungarrison":
+			if open != nil {
+				open.EndSecs = command.GameTimeSecs
+				open.DurationSecs = open.EndSecs - open.StartSecs
+				open.Ended = true
+				periods = append(periods, *open)
+				open = nil
+			}
+		}
+	}
+	if open != nil {
+		lastCommand := (*playerCommandList)[len(*playerCommandList)-1]
+		open.EndSecs = lastCommand.GameTimeSecs
+		open.DurationSecs = open.EndSecs - open.StartSecs
+		periods = append(periods, *open)
+	}
+	return periods
+}
+
+func calcWalls(playerCommandList *[]ReplayGameCommand) []WallItem {
+	walls := make([]WallItem, 0)
+	for _, command := range *playerCommandList {
+		if command.CommandType == "buildWall" {
+			payload := command.Payload.(BuildWallPayload)
+			walls = append(walls, WallItem{
+				GameTimeSecs: command.GameTimeSecs,
+				Length:       payload.Length,
+			})
+		}
+	}
+	return walls
+}
+
 func calcEAPMOverTime(rawCommandList *[]RawGameCommand) []float64 {
 	lastCommand := (*rawCommandList)[len(*rawCommandList)-1]
 	minutes := int(math.Ceil(lastCommand.GameTimeSecs() / 60.0))
@@ -0,0 +1,65 @@
+package parser_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+const (
+	gold = 0
+	wood = 1
+	food = 2
+)
+
+func TestTributes(t *testing.T) {
+	replay := testReplay(
+		encoder.Tribute{CommandHeader: at(1, 40), Recipient: 3, ResourceType: food, Amount: 10},
+		encoder.Tribute{CommandHeader: at(3, 60), Recipient: 1, ResourceType: wood, Amount: 100},
+		encoder.Tribute{CommandHeader: at(2, 80), Recipient: 1, ResourceType: food, Amount: 50, Tax: 0.1},
+		encoder.Tribute{CommandHeader: at(1, 100), Recipient: 2, ResourceType: gold, Amount: 200, Tax: 0.2},
+		encoder.Resign{CommandHeader: at(2, 200)},
+	)
+	// carol plays with alice
+	replay.Players = append(replay.Players, encoder.Player{Num: 3, Name: "carol", ProfileId: "1003", Team: 1, Civ: 1})
+
+	formatted, err := parser.Parse(writeReplay(t, replay), parser.ParseOptions{Stats: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stats := *formatted.Stats
+
+	received := map[int]map[string]float32{
+		1: {"wood": 100, "food": 45},
+		2: {"gold": 160},
+		3: {"food": 10},
+	}
+	for playerNum, want := range received {
+		if !reflect.DeepEqual(stats[playerNum].TributeReceived, want) {
+			t.Errorf("player %d TributeReceived = %v, want %v", playerNum, stats[playerNum].TributeReceived, want)
+		}
+	}
+
+	// Player 1's timeline holds what they sent and, after the tax, what they received, in game time order
+	wantTimeline := []parser.TributeItem{
+		{GameTimeSecs: 2, From: 1, To: 3, ResourceType: "food", Amount: 10},
+		{GameTimeSecs: 3, From: 3, To: 1, ResourceType: "wood", Amount: 100},
+		{GameTimeSecs: 4, From: 2, To: 1, ResourceType: "food", Amount: 45},
+		{GameTimeSecs: 5, From: 1, To: 2, ResourceType: "gold", Amount: 200},
+	}
+	if !reflect.DeepEqual(stats[1].Timelines.Tributes, wantTimeline) {
+		t.Errorf("player 1 tribute timeline = %+v, want %+v", stats[1].Timelines.Tributes, wantTimeline)
+	}
+
+	wantFlows := []parser.TeamTributeFlow{
+		{FromTeam: 1, ToTeam: 1, ResourceType: "food", Amount: 10},
+		{FromTeam: 1, ToTeam: 1, ResourceType: "wood", Amount: 100},
+		{FromTeam: 2, ToTeam: 1, ResourceType: "food", Amount: 50},
+		{FromTeam: 1, ToTeam: 2, ResourceType: "gold", Amount: 200},
+	}
+	if !reflect.DeepEqual(*formatted.TeamTributes, wantFlows) {
+		t.Errorf("team tributes = %+v, want %+v", *formatted.TeamTributes, wantFlows)
+	}
+}
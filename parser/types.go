@@ -103,9 +103,10 @@ func (node NotRootNodeError) Error() string {
 }
 
 type CommandList struct {
-	entryIdx  int
-	offsetEnd int
-	commands  []RawGameCommand
+	entryIdx      int
+	offsetEnd     int
+	commands      []RawGameCommand
+	selectedUnits []uint32
 }
 
 type FooterNotFoundError int
@@ -155,6 +156,11 @@ type ReplayFormatted struct {
 	GameOptions    map[string]bool
 	Players        []ReplayPlayer
 	Stats          *map[int]ReplayStats // Map of player number to stats
+	TeamTributes   *[]TeamTributeFlow
+	Selections     *[]SelectionSnapshot  `json:",omitempty"`
+	Units          *map[int][]UnitRecord `json:",omitempty"` // Map of player number to the unit ids they used
+	RawCommands    *[]RawCommandEntry    `json:",omitempty"`
+	Diplomacy      *ReplayDiplomacy      `json:",omitempty"` // Built from the game commands, so left out of slim parses
 	GameCommands   *[]ReplayGameCommand
 }
 
@@ -172,6 +178,73 @@ type ReplayPlayer struct {
 	Titan     bool
 	Wonder    bool
 	CivList   string `json:"civ_list"`
+	AgeUps    []AgeUpItem
+	Opening   string `json:",omitempty"`
+}
+
+// AgeUpItem is a single age advance for a player. ClickedAtSecs is when the age-up was researched or prequeued,
+// CompletedAtSecs is an estimate based on the research (or, for the Wonder age, build) time in the XMB data.
+type AgeUpItem struct {
+	Age             string
+	Name            string
+	Prequeued       bool
+	ClickedAtSecs   float64
+	DurationSecs    float64
+	CompletedAtSecs float64
+}
+
+// ReplayDiplomacy is the diplomacy state of the game. FinalStances maps a player to their stance towards every other
+// player at the end of the game, Changes lists every stance change in the order they happened.
+type ReplayDiplomacy struct {
+	FinalStances map[int]map[int]string
+	Changes      []DiplomacyChange
+}
+
+type DiplomacyChange struct {
+	GameTimeSecs   float64
+	PlayerNum      int
+	TargetPlayer   int
+	PreviousStance string
+	Stance         string
+}
+
+// ReplayBuildOrder is the output of the buildorder command, the opening of each player up to UntilSecs
+type ReplayBuildOrder struct {
+	MapName   string
+	UntilSecs float64
+	Players   []PlayerBuildOrder
+}
+
+type PlayerBuildOrder struct {
+	PlayerNum int
+	Name      string
+	God       string
+	AgeUps    []AgeUpItem
+	Steps     []BuildOrderStep
+}
+
+// BuildOrderStep is one line of a build order. Action is the formatted command type, except that age-up research and
+// prequeues are reported as "ageUp". Count is the number of items folded into this step, a shift-click train counts
+// every unit of the batch.
+type BuildOrderStep struct {
+	GameTimeSecs float64
+	Action       string
+	Name         string
+	Count        int
+}
+
+// RuleCheckResult is the output of the check command for a single replay
+type RuleCheckResult struct {
+	ReplayPath string
+	Fired      []FiredRule
+}
+
+type FiredRule struct {
+	Rule              string
+	PlayerNum         int
+	PlayerName        string
+	Count             int
+	FirstGameTimeSecs float64
 }
 
 type ReplayGameCommand struct {
@@ -179,18 +252,78 @@ type ReplayGameCommand struct {
 	PlayerNum    int
 	CommandType  string
 	Payload      interface{}
+	// Modifiers are only set when the player held a modifier key (or an unknown bit of the flags is set)
+	Modifiers *CommandModifiers `json:",omitempty"`
+	// SourceUnits are the ids of the units that received the command, only populated when asked for
+	SourceUnits []uint32 `json:",omitempty"`
+}
+
+// RawCommandEntry is a game command as it was read from the command stream, including the commands GameCommands
+// leaves out because they have no formatter. Name is the best-known name of the command type, Offset is where the body
+// starts in the replay and PreArgumentBytes and Body are hex encoded.
+type RawCommandEntry struct {
+	CommandType      int
+	Name             string
+	PlayerNum        int
+	Tick             int
+	Offset           int
+	ByteLength       int
+	PreArgumentBytes string
+	SourceUnits      []uint32
+	Body             string
 }
 
+// UnitRecord is everything we know about a unit id a player used. Proto is inferred from the train or build click
+// (ProtoSource) that most likely produced the unit and is empty when no click could be linked. A finished transform
+// replaces it with the proto the unit transformed into, with "transform" as the ProtoSource.
+type UnitRecord struct {
+	UnitId            uint32
+	Proto             string `json:",omitempty"`
+	ProtoSource       string `json:",omitempty"`
+	FirstSeenSecs     float64
+	LastSeenSecs      float64
+	LastCommandedSecs float64
+	CommandCount      int
+	Deleted           bool
+	DeletedAtSecs     float64 `json:",omitempty"`
+}
+
+// SelectionSnapshot is the unit selection recorded with a command list (game tick). Only ticks where the selection
+// was recorded have a snapshot.
+type SelectionSnapshot struct {
+	GameTimeSecs float64
+	Units        []uint32
+}
+
+// ReplayStats holds the per player stats. UnitCounts and TechsResearched are net of cancelled queue items, the Gross
+// variants count everything that was queued. Trains count every unit of a batch. Buildings can't be cancelled through
+// a queue, so BuildingCounts and GrossBuildingCounts are the same.
 type ReplayStats struct {
-	Trade           TradeStats
-	UnitCounts      map[string]int
-	BuildingCounts  map[string]int
-	GodPowerCounts  map[string]int
-	FormationCounts map[string]int
-	TauntCounts     map[string]int
-	TechsResearched []string
-	EAPM            []float64
-	Timelines       Timelines
+	Trade                TradeStats
+	UnitCounts           map[string]int
+	BuildingCounts       map[string]int
+	GodPowerCounts       map[string]int
+	FormationCounts      map[string]int
+	TauntCounts          map[string]int
+	TributeSent          map[string]float32
+	TributeReceived      map[string]float32
+	TechsResearched      []string
+	GrossUnitCounts      map[string]int
+	GrossBuildingCounts  map[string]int
+	GrossTechsResearched []string
+	CancelledCounts      map[string]int
+	SelfDeleteCounts     map[string]int
+	TransformCounts      map[string]int
+	StanceCounts         map[string]int
+	PrebuyGodPowerCounts map[string]int
+	AutoAbilityToggles   map[string]int
+	ControlGroups        ControlGroupStats
+	UnderRaidSecs        float64
+	WallSegments         int
+	WallLength           float64
+	EcoLayout            EcoLayout
+	EAPM                 []float64
+	Timelines            Timelines
 }
 
 type TradeStats struct {
@@ -214,4 +347,149 @@ type Timelines struct {
 	TechsPrequeued  []TechItem
 	TechsResearched []TechItem
 	GodPowers       []GodPowerItem
+	Tributes        []TributeItem
+	ControlGroups   []ControlGroupItem
+	UnderRaid       []RaidPeriod
+	Walls           []WallItem
+}
+
+// EcoLayout relates a player's gather points to their dropsites. Distances are -1 when there is nothing to compare
+// against, AverageDropsiteDistance only averages the gather points that had a dropsite.
+type EcoLayout struct {
+	Buildings               []EcoBuildingPlacement
+	GatherPoints            []GatherPointPlacement
+	AverageDropsiteDistance float64
+}
+
+type EcoBuildingPlacement struct {
+	Name                       string
+	GameTimeSecs               float64
+	Location                   Vector3
+	NearestGatherPointDistance float64
+}
+
+type GatherPointPlacement struct {
+	GameTimeSecs     float64
+	Location         Vector3
+	NearestDropsite  string
+	DropsiteDistance float64
+}
+
+// WallItem is one wall segment a player placed, Length is the distance between the segment's start and end points
+type WallItem struct {
+	GameTimeSecs float64
+	Length       float64
+}
+
+// RaidPeriod is a stretch of time a player's economy was hiding from a raid, from the first town bell or seek shelter
+// to the following ungarrison. Trigger is the command type that started the period. A period the player never ended
+// lasts until their last command and has Ended set to false.
+type RaidPeriod struct {
+	StartSecs    float64
+	EndSecs      float64
+	DurationSecs float64
+	Trigger      string
+	Ended        bool
+}
+
+// ControlGroupStats summarizes how a player used control groups. AssignmentCounts is keyed by the control group
+// number, Reassignments counts the times a group that already held units was overwritten with a new set of units.
+type ControlGroupStats struct {
+	GroupsUsed       []int
+	AssignmentCounts map[int]int
+	Reassignments    int
+}
+
+// ControlGroupItem is one change to a control group. The game records a change unit by unit, the units changed in the
+// same tick are collapsed into one item. A tick that both removes and adds units is a "set".
+type ControlGroupItem struct {
+	GameTimeSecs float64
+	Group        int
+	Operation    string
+	NumUnits     int
+}
+
+// TributeItem is a tribute sent or received by a player, Amount is what was sent for sent tributes and what arrived
+// (after the tribute tax) for received ones.
+type TributeItem struct {
+	GameTimeSecs float64
+	From         int
+	To           int
+	ResourceType string
+	Amount       float32
+}
+
+// TeamTributeFlow is one cell of the team tribute matrix, the total amount of a resource a team sent to another team
+type TeamTributeFlow struct {
+	FromTeam     int
+	ToTeam       int
+	ResourceType string
+	Amount       float32
+}
+
+// CommandResearch summarizes every instance of one command type across a corpus of replays, see ResearchCommands.
+// ByteLengths maps a body length in the layout to the number of samples that had it. The samples run up to
+// researchTrailingBytes further, so Positions may go past the longest body.
+type CommandResearch struct {
+	CommandType int
+	NumReplays  int
+	NumSamples  int
+	ByteLengths map[int]int
+	Positions   []BytePositionStats
+}
+
+// BytePositionStats describes the values a single byte of a command body takes. ConstantRate is the share of samples
+// holding the most common value, SameAsPreviousRate the share holding the same value as the player's previous sample.
+// TimeCorrelation is the Pearson correlation of the byte value with game time. The associations are Cramér's V of the
+// byte value with the player id and the type of the previous and next command in the stream, which are categories.
+type BytePositionStats struct {
+	Position               int
+	NumSamples             int
+	DistinctValues         int
+	ConstantRate           float64
+	SameAsPreviousRate     float64
+	TimeCorrelation        float64
+	PlayerAssociation      float64
+	PrevCommandAssociation float64
+	NextCommandAssociation float64
+	TopValues              []ByteValueCount
+}
+
+type ByteValueCount struct {
+	Value uint8
+	Count int
+}
+
+// LayoutDiagnosis is the result of DiagnoseLayouts. ListsParsed is the number of command lists that parse with the
+// current layouts, FailedAtOffset where the stream stops parsing (-1 when it doesn't) and SuspectTypes the command
+// types whose lengths were searched, closest to the failure first.
+type LayoutDiagnosis struct {
+	CommandCount   int
+	ListsParsed    int
+	FailedAtOffset int
+	Error          string
+	SuspectTypes   []int
+	Candidates     []LayoutCandidate
+}
+
+// LayoutCandidate is an alternate body length for a command type that gets further through the command stream than
+// the current one. Clean is set when every command list parses with it.
+type LayoutCandidate struct {
+	CommandType   int
+	Name          string
+	CurrentLength int
+	ByteLength    int
+	ListsParsed   int
+	Clean         bool
+	Error         string
+}
+
+// PlayerAlias is how AnonymizeReplay renamed a player. AliasProfileId is the negated player number, ProfileId and
+// AliasProfileId are empty for AI players.
+type PlayerAlias struct {
+	PlayerNum      int
+	Name           string
+	Alias          string
+	ProfileId      string
+	AliasProfileId string
 }
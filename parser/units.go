@@ -0,0 +1,274 @@
+package parser
+
+import (
+	"log/slog"
+	"sort"
+)
+
+// Unit ids never show up on their own in the replay, they are only referenced by commands: as the units receiving a
+// command (source units), in the per tick selection, or as the target of a task, delete, ungarrison or transform. This
+// file stitches those references together into a registry of the unit ids each player used.
+//
+// The proto of a unit is inferred, not read. The game hands out unit ids sequentially, so a unit that first shows up
+// after a train (or build) click and has a higher id than anything seen at the time of that click is most likely the
+// unit that click produced. New ids that act as a building (they train or research something) are matched against the
+// player's pending build clicks, everything else against pending trains, oldest first.
+
+// unitSpawnExpirySecs is how long a train or build click waits to be matched to a new unit id before we assume the
+// unit was never commanded (or the click was cancelled) and stop considering it.
+const unitSpawnExpirySecs = 300.0
+
+type pendingSpawn struct {
+	proto        string
+	source       string
+	maxIdAtClick uint32
+	clickedSecs  float64
+}
+
+// protoChange is a finished transform, proto is what the unit was before gameTimeSecs
+type protoChange struct {
+	gameTimeSecs float64
+	proto        string
+}
+
+type unitRegistry struct {
+	units   map[int]map[uint32]*UnitRecord
+	pending map[int][]*pendingSpawn
+	// transforms holds the proto changes of every unit in game time order, the record has the proto after the last one
+	transforms map[int]map[uint32][]protoChange
+	maxId      uint32
+}
+
+func newUnitRegistry() *unitRegistry {
+	return &unitRegistry{
+		units:      make(map[int]map[uint32]*UnitRecord),
+		pending:    make(map[int][]*pendingSpawn),
+		transforms: make(map[int]map[uint32][]protoChange),
+	}
+}
+
+// touch registers a reference to a unit, creating the record the first time the unit is seen
+func (registry *unitRegistry) touch(playerNum int, unitId uint32, gameTimeSecs float64, asBuilding bool) *UnitRecord {
+	playerUnits, exists := registry.units[playerNum]
+	if !exists {
+		playerUnits = make(map[uint32]*UnitRecord)
+		registry.units[playerNum] = playerUnits
+	}
+
+	record, exists := playerUnits[unitId]
+	if !exists {
+		record = &UnitRecord{
+			UnitId:        unitId,
+			FirstSeenSecs: gameTimeSecs,
+		}
+		registry.inferProto(playerNum, record, asBuilding)
+		playerUnits[unitId] = record
+	}
+	record.LastSeenSecs = gameTimeSecs
+	if unitId > registry.maxId {
+		registry.maxId = unitId
+	}
+	return record
+}
+
+func (registry *unitRegistry) inferProto(playerNum int, record *UnitRecord, asBuilding bool) {
+	wantedSource := "train"
+	if asBuilding {
+		wantedSource = "build"
+	}
+
+	pending := registry.pending[playerNum]
+	kept := pending[:0]
+	matched := false
+	for _, spawn := range pending {
+		if record.FirstSeenSecs-spawn.clickedSecs > unitSpawnExpirySecs {
+			continue
+		}
+		if !matched && spawn.source == wantedSource && record.UnitId > spawn.maxIdAtClick {
+			record.Proto = spawn.proto
+			record.ProtoSource = spawn.source
+			matched = true
+			continue
+		}
+		kept = append(kept, spawn)
+	}
+	registry.pending[playerNum] = kept
+}
+
+func (registry *unitRegistry) addPending(playerNum int, proto string, source string, count int, gameTimeSecs float64) {
+	for i := 0; i < count; i++ {
+		registry.pending[playerNum] = append(registry.pending[playerNum], &pendingSpawn{
+			proto:        proto,
+			source:       source,
+			maxIdAtClick: registry.maxId,
+			clickedSecs:  gameTimeSecs,
+		})
+	}
+}
+
+// lookup returns the record of a unit id the player has already used, or nil
+func (registry *unitRegistry) lookup(playerNum int, unitId uint32) *UnitRecord {
+	playerUnits, exists := registry.units[playerNum]
+	if !exists {
+		return nil
+	}
+	return playerUnits[unitId]
+}
+
+// protoOf returns the inferred proto a unit id the player has used had when a command at gameTimeSecs was issued, or
+// "unknown" if it couldn't be inferred. A transform finishing at gameTimeSecs hasn't happened yet.
+func (registry *unitRegistry) protoOf(playerNum int, unitId uint32, gameTimeSecs float64) string {
+	if registry == nil {
+		return "unknown"
+	}
+	record := registry.lookup(playerNum, unitId)
+	if record == nil {
+		return "unknown"
+	}
+	proto := record.Proto
+	for _, change := range registry.transforms[playerNum][unitId] {
+		if change.gameTimeSecs >= gameTimeSecs {
+			proto = change.proto
+			break
+		}
+	}
+	if proto == "" {
+		return "unknown"
+	}
+	return proto
+}
+
+// transform records that a unit finished transforming into proto at gameTimeSecs
+func (registry *unitRegistry) transform(playerNum int, record *UnitRecord, proto string, gameTimeSecs float64) {
+	if registry.transforms[playerNum] == nil {
+		registry.transforms[playerNum] = make(map[uint32][]protoChange)
+	}
+	registry.transforms[playerNum][record.UnitId] = append(
+		registry.transforms[playerNum][record.UnitId],
+		protoChange{gameTimeSecs: gameTimeSecs, proto: record.Proto},
+	)
+	record.Proto = proto
+	record.ProtoSource = "transform"
+}
+
+func (registry *unitRegistry) records() map[int][]UnitRecord {
+	recordsByPlayer := make(map[int][]UnitRecord)
+	for playerNum, playerUnits := range registry.units {
+		records := make([]UnitRecord, 0, len(playerUnits))
+		for _, record := range playerUnits {
+			records = append(records, *record)
+		}
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].UnitId < records[j].UnitId
+		})
+		recordsByPlayer[playerNum] = records
+	}
+	return recordsByPlayer
+}
+
+// actsAsBuilding reports whether the source units of a command must be buildings
+func actsAsBuilding(command RawGameCommand) bool {
+	switch command.(type) {
+	case TrainCommand, ResearchCommand, AutoqueueCommand, CancelQueuedItemCommand:
+		return true
+	}
+	return false
+}
+
+func buildUnitRegistry(
+	commandList *[]RawGameCommand,
+	selections *[]SelectionSnapshot,
+	formatterInput FormatterInput,
+) *unitRegistry {
+	registry := newUnitRegistry()
+
+	selectionIdx := 0
+	tickPlayers := make(map[int]bool)
+	tickSecs := -1.0
+	for _, command := range *commandList {
+		if command.GameTimeSecs() != tickSecs {
+			selectionIdx = registry.addSelections(selections, selectionIdx, command.GameTimeSecs(), tickSecs, tickPlayers)
+			tickPlayers = make(map[int]bool)
+			tickSecs = command.GameTimeSecs()
+		}
+		tickPlayers[command.PlayerId()] = true
+		registry.addCommand(command, formatterInput)
+	}
+	registry.addSelections(selections, selectionIdx, -1, tickSecs, tickPlayers)
+
+	slog.Debug("Built unit registry", "numPlayers", len(registry.units))
+	return registry
+}
+
+// addSelections registers the selections recorded before the tick at beforeSecs (or all remaining ones when
+// beforeSecs is negative). The selection block doesn't record a player, so a selection is only attributed when it was
+// recorded in the tick at tickSecs and a single player issued commands in that tick.
+func (registry *unitRegistry) addSelections(
+	selections *[]SelectionSnapshot,
+	selectionIdx int,
+	beforeSecs float64,
+	tickSecs float64,
+	tickPlayers map[int]bool,
+) int {
+	for ; selectionIdx < len(*selections); selectionIdx++ {
+		selection := (*selections)[selectionIdx]
+		if beforeSecs >= 0 && selection.GameTimeSecs >= beforeSecs {
+			break
+		}
+		if selection.GameTimeSecs != tickSecs || len(tickPlayers) != 1 {
+			continue
+		}
+		for playerNum := range tickPlayers {
+			for _, unitId := range selection.Units {
+				registry.touch(playerNum, unitId, selection.GameTimeSecs, false)
+			}
+		}
+	}
+	return selectionIdx
+}
+
+func (registry *unitRegistry) addCommand(command RawGameCommand, formatterInput FormatterInput) {
+	playerNum := command.PlayerId()
+	gameTimeSecs := command.GameTimeSecs()
+
+	for _, unitId := range command.SourceUnits() {
+		record := registry.touch(playerNum, unitId, gameTimeSecs, actsAsBuilding(command))
+		record.LastCommandedSecs = gameTimeSecs
+		record.CommandCount++
+	}
+
+	switch cmd := command.(type) {
+	case TrainCommand:
+		proto := protoName(formatterInput.protoRootNode, cmd.protoUnitId)
+		registry.addPending(playerNum, proto, "train", cmd.batchSize(), gameTimeSecs)
+	case BuildCommand:
+		proto := protoName(formatterInput.protoRootNode, cmd.protoBuildingId)
+		registry.addPending(playerNum, proto, "build", 1, gameTimeSecs)
+	case TaskCommand:
+		// Task targets are usually someone else's units or resources, only update units we know the player owns
+		if cmd.targetUnitId >= 0 {
+			if record := registry.lookup(playerNum, uint32(cmd.targetUnitId)); record != nil {
+				record.LastSeenSecs = gameTimeSecs
+			}
+		}
+	case UngarrisonCommand:
+		if cmd.unitId >= 0 {
+			registry.touch(playerNum, uint32(cmd.unitId), gameTimeSecs, false)
+		}
+	case StartUnitTransformCommand:
+		if cmd.unitId >= 0 {
+			registry.touch(playerNum, uint32(cmd.unitId), gameTimeSecs, false)
+		}
+	case FinishUnitTransformCommand:
+		if cmd.unitId >= 0 {
+			record := registry.touch(playerNum, uint32(cmd.unitId), gameTimeSecs, false)
+			registry.transform(playerNum, record, protoName(formatterInput.protoRootNode, cmd.protoUnitId), gameTimeSecs)
+		}
+	case DeleteCommand:
+		for _, unitId := range cmd.deletedUnits() {
+			record := registry.touch(playerNum, unitId, gameTimeSecs, false)
+			record.Deleted = true
+			record.DeletedAtSecs = gameTimeSecs
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/jerkeeler/restoration/encoder"
+	"github.com/jerkeeler/restoration/parser"
+)
+
+func TestFinishedTransformChangesTheProto(t *testing.T) {
+	replayPath := writeReplay(t, testReplay(
+		encoder.Train{CommandHeader: at(1, 20, 100), ProtoUnitId: villager},
+		// The first new id after the train is the villager it produced
+		encoder.Task{CommandHeader: at(1, 400, 101), TargetUnitId: -1},
+		encoder.StartUnitTransform{CommandHeader: at(1, 420, 101), UnitId: 101, ProtoUnitId: hoplite},
+		encoder.FinishUnitTransform{CommandHeader: at(1, 500, 101), UnitId: 101, ProtoUnitId: hoplite},
+		encoder.Delete{CommandHeader: at(1, 600, 101), UnitId: 101},
+		encoder.Resign{CommandHeader: at(2, 700)},
+	))
+
+	formatted, err := parser.Parse(replayPath, parser.ParseOptions{Stats: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	transformsFrom := make([]string, 0)
+	var deleted parser.DeletePayload
+	for _, command := range *formatted.GameCommands {
+		switch payload := command.Payload.(type) {
+		case parser.TransformPayload:
+			transformsFrom = append(transformsFrom, payload.From+"->"+payload.To)
+		case parser.DeletePayload:
+			deleted = payload
+		}
+	}
+	// Both the start and the finish are formatted with the proto the unit had before the transform
+	if len(transformsFrom) != 2 || transformsFrom[0] != "Villager->Hoplite" || transformsFrom[1] != "Villager->Hoplite" {
+		t.Errorf("transforms = %v, want Villager->Hoplite twice", transformsFrom)
+	}
+	if len(deleted.Units) != 1 || deleted.Units[0].Proto != "Hoplite" {
+		t.Errorf("deleted units = %+v, want the transformed Hoplite", deleted.Units)
+	}
+
+	var record *parser.UnitRecord
+	for _, unit := range (*formatted.Units)[1] {
+		if unit.UnitId == 101 {
+			record = &unit
+		}
+	}
+	if record == nil || record.Proto != "Hoplite" || record.ProtoSource != "transform" {
+		t.Errorf("unit 101 = %+v, want a Hoplite from a transform", record)
+	}
+}
@@ -177,3 +177,18 @@ func parseXmbNode(data *[]byte, offset int, elements []string, attributes []stri
 		endOffset:   offset,
 	}, nil
 }
+
+// findXmbChild returns the first direct child of node with the given element name, or nil if there is none. Most
+// XMB entries (a tech in the techtree, a unit in proto) store their properties as child elements, e.g.
+// <tech name="ClassicalAgeAthena"><researchpoints>60.0000</researchpoints></tech>.
+func findXmbChild(node *XmbNode, elementName string) *XmbNode {
+	if node == nil {
+		return nil
+	}
+	for _, child := range node.children {
+		if child.elementName == elementName {
+			return child
+		}
+	}
+	return nil
+}
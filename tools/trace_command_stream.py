@@ -7,14 +7,18 @@ offsets, types, and body lengths. Stops at the first parse failure with a
 precise diagnostic.
 
 Mirrors the Go parser's command-stream walker
-(parser/gameCommandParser.go::parseCommandList + parseGameCommand) and the
-per-refiner byte lengths from parser/gameCommands.go. **Will drift** —
-re-sync REFINER_LENGTH when a refiner changes. See tools/CLAUDE.md.
+(parser/gameCommandParser.go::parseCommandList + parseGameCommand). Body
+lengths come from the parser's own layout table (parser/layouts.go) via
+`restoration layouts --json`, so there is no second copy to keep in sync. By
+default the script runs `restoration` from PATH, or `go run .` in the repo
+root when it isn't installed.
 
 Usage:
     uv run tools/trace_command_stream.py <replay.mythrec>
     uv run tools/trace_command_stream.py <replay.mythrec> --quiet
     uv run tools/trace_command_stream.py <replay.mythrec> --prequeue-tech-bytes 13
+    uv run tools/trace_command_stream.py <replay.mythrec> --restoration ./restoration
+    uv run tools/trace_command_stream.py <replay.mythrec> --layouts layouts.json
 
 The --prequeue-tech-bytes knob lets you A/B test alternate body lengths for
 command type 72 (prequeueTech) when a patch shifts its layout.
@@ -22,51 +26,54 @@ command type 72 (prequeueTech) when a patch shifts its layout.
 
 import argparse
 import gzip
+import json
+import shutil
 import struct
+import subprocess
 import sys
 from pathlib import Path
 
-# Refiner byte lengths, sourced from parser/gameCommands.go (Refine methods).
-# Re-check these against the source of truth when adding to it. Commands marked
-# "var" compute their length from a probe and need a special case below.
-REFINER_LENGTH = {
-    0: 44,    # task: 4 int32 + vector + float + 3 int32
-    1: 12,    # research: 3 int32
-    2: 18,    # train: 4 int32 + 2 int8
-    3: 52,    # build
-    4: 32,    # setGatherPoint: 2 int32 + vector + float + 2 int32
-    7: 9,    # delete: 2 int32 + int8
-    9: 8,    # stop: 2 int32
-    12: 57,   # useProtoPower
-    13: 20,   # marketBuySell
-    14: 8,    # ungarrison: 2 int32
-    16: 21,   # resign: 5 int32 + int8
-    18: 12,   # unknown 18: 3 int32
-    19: 25,   # tribute: 4 int32 + 2 float + int8
-    23: 18,   # finishUnitTransform: 4 int32 + 2 int8
-    25: 14,   # setUnitStance: 2 int32 + 2 int8 + int32
-    26: 13,   # changeDiplomacy: 2 int32 + int8 + int32
-    34: 8,    # townBell
-    35: 12,   # autoScoutEvent
-    37: 13,   # changeControlGroup: 2 int32 + int8 + int32
-    38: 12,   # repair: 3 int32
-    39: 12,   # unknown 39: 3 int32
-    41: 45,   # taunt
-    44: 16,   # cheat
-    45: 20,   # cancelQueuedItem: 5 int32
-    48: 16,   # setFormation
-    53: 12,   # startUnitTransform: 3 int32
-    55: 20,   # unknown 55: 2 int32 + vector
-    66: 12,   # autoqueue
-    67: 9,    # toggleAutoUnitAbility: 2 int32 + int8
-    68: 32,   # timeShift
-    69: 36,   # buildWallConnector: 3 int32 + 2 vector
-    71: 12,   # seekShelter: 3 int32
-    72: 16,   # prequeueTech (build >= 601511); --prequeue-tech-bytes overrides
-    73: 8,    # unknown 73: 2 int32
-    75: 16,   # prebuyGodPower
-    78: -1,   # unknown 78: 20 or 28 depending on probe (handled below)
-}
+REPO_ROOT = Path(__file__).resolve().parent.parent
+
+
+def export_layouts(restoration: str | None) -> list:
+    """Run `restoration layouts --json`, falling back to `go run .` in the repo root."""
+    if restoration is None:
+        restoration = shutil.which("restoration")
+    argv = [restoration] if restoration else ["go", "run", "."]
+    try:
+        out = subprocess.run(argv + ["layouts", "--json"], cwd=REPO_ROOT,
+                             check=True, capture_output=True, text=True).stdout
+    except (OSError, subprocess.CalledProcessError) as err:
+        raise SystemExit(f"could not export layouts with {' '.join(argv)}: {err}\n"
+                         "pass --restoration or --layouts")
+    return json.loads(out)
+
+
+def current_layouts(layouts: list) -> dict:
+    """Group the current-build layouts by command type, default variant first."""
+    by_type = {}
+    for layout in layouts:
+        if layout["MaxBuild"] == 0:
+            by_type.setdefault(layout["CommandType"], []).append(layout)
+    for variants in by_type.values():
+        variants.sort(key=lambda layout: layout["Variant"] != "")
+    return by_type
+
+
+def variant_matches(data: bytes, body_start: int, layout: dict) -> bool:
+    """Evaluate a variant condition of the form `<field> == <int32>` against the body."""
+    field_name, value = (part.strip() for part in layout["Condition"].split("=="))
+    field = next(f for f in layout["Fields"] if f["Name"] == field_name)
+    return struct.unpack_from("<i", data, body_start + field["Offset"])[0] == int(value)
+
+
+def body_length(data: bytes, body_start: int, variants: list) -> int:
+    """Pick the variant whose condition holds, or the default layout."""
+    for layout in variants[1:]:
+        if variant_matches(data, body_start, layout):
+            return layout["ByteLength"]
+    return variants[0]["ByteLength"]
 
 
 def u16(d: bytes, o: int) -> int:
@@ -77,7 +84,7 @@ def u32(d: bytes, o: int) -> int:
     return struct.unpack_from("<I", d, o)[0]
 
 
-def parse_game_command(data, offset, prequeue_bytes):
+def parse_game_command(data, offset, layouts, prequeue_bytes):
     """Mirror parseGameCommand. Returns (next_offset, type, info, start)."""
     start = offset
     cmd_type = data[offset + 1]
@@ -107,19 +114,17 @@ def parse_game_command(data, offset, prequeue_bytes):
     npa = 13 + u16(data, offset); offset += 4 + npa
 
     body_start = offset
-    if cmd_type == 78:
-        bl = 28 if u32(data, offset + 12) == 3 else 20
-    elif cmd_type == 72:
+    if cmd_type == 72 and prequeue_bytes is not None:
         bl = prequeue_bytes
-    elif cmd_type in REFINER_LENGTH and REFINER_LENGTH[cmd_type] > 0:
-        bl = REFINER_LENGTH[cmd_type]
+    elif cmd_type in layouts:
+        bl = body_length(data, body_start, layouts[cmd_type])
     else:
         return None, cmd_type, f"unregistered command type {cmd_type}", start
 
     return offset + bl, cmd_type, f"body=0x{body_start:x}+{bl}", start
 
 
-def parse_command_list(data, offset, prequeue_bytes):
+def parse_command_list(data, offset, layouts, prequeue_bytes):
     list_start = offset
     et = u32(data, offset); offset += 5  # entryType + earlyByte
     if et & 225 != et:
@@ -135,7 +140,7 @@ def parse_command_list(data, offset, prequeue_bytes):
         else:
             ni = u32(data, offset); offset += 4
         for i in range(ni):
-            no, ct, info, cs = parse_game_command(data, offset, prequeue_bytes)
+            no, ct, info, cs = parse_game_command(data, offset, layouts, prequeue_bytes)
             if no is None:
                 return None, f"cmd #{i+1} @0x{cs:x} type={ct}: {info}", log
             log.append(f"  cmd#{i+1} @0x{cs:x} type={ct} -> 0x{no:x} ({info})")
@@ -172,9 +177,17 @@ def main() -> None:
     ap.add_argument("--gz", action="store_true")
     ap.add_argument("--quiet", action="store_true",
                     help="only print first/last few lists and the failure")
-    ap.add_argument("--prequeue-tech-bytes", type=int, default=16,
-                    help="body length for command type 72 (default: 16)")
+    ap.add_argument("--prequeue-tech-bytes", type=int, default=None,
+                    help="body length for command type 72 (default: from the layouts)")
+    ap.add_argument("--restoration",
+                    help="restoration binary to export layouts with (default: from PATH, else `go run .`)")
+    ap.add_argument("--layouts", type=Path,
+                    help="saved output of `restoration layouts --json` to use instead of running it")
     args = ap.parse_args()
+    if args.layouts:
+        layouts = current_layouts(json.loads(args.layouts.read_text()))
+    else:
+        layouts = current_layouts(export_layouts(args.restoration))
 
     raw = args.replay.read_bytes()
     if args.gz or args.replay.suffix == ".gz":
@@ -190,7 +203,7 @@ def main() -> None:
     offset = start
     n = 0
     while offset < len(raw):
-        next_off, err, log = parse_command_list(raw, offset, args.prequeue_tech_bytes)
+        next_off, err, log = parse_command_list(raw, offset, layouts, args.prequeue_tech_bytes)
         if err is not None:
             print(f"\nFAIL at list #{n+1} (offset 0x{offset:x}): {err}")
             for line in log: